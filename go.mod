@@ -6,6 +6,7 @@ require (
 	github.com/hairyhenderson/go-which v0.2.0
 	github.com/pelletier/go-toml v1.9.5
 	github.com/spf13/afero v1.10.0
+	golang.org/x/crypto v0.33.0
 )
 
-require golang.org/x/text v0.3.7 // indirect
+require golang.org/x/text v0.22.0 // indirect
@@ -16,6 +16,6 @@ func main() {
 		return
 	}
 
-	// Print the obtained token
-	fmt.Printf("Obtained token: %v\n", token.Token)
+	// Print the obtained token, which only shows its access ID, expiry and fingerprint
+	fmt.Printf("Obtained token: %v\n", token)
 }
@@ -0,0 +1,83 @@
+package sheller
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// writeFileAtomic writes data to path through config.AppFs so readers never observe a partially written file.
+// The data is written to a hidden temporary file in the same directory, which is then renamed over path.
+func writeFileAtomic(config *Config, path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := writeTempFile(config, path, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := config.AppFs.Rename(tmpPath, path); err != nil {
+		config.AppFs.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeFileExclusive is like writeFileAtomic but fails with an error matching os.ErrExist instead of replacing
+// a file that already exists at path. On the local filesystem the complete temporary file is published with a
+// hard link, which fails if path exists. Filesystems without hard links fall back to creating path exclusively
+// and writing it in place, where a reader may briefly see it empty.
+func writeFileExclusive(config *Config, path string, data []byte, perm os.FileMode) error {
+	if _, ok := config.AppFs.Fs.(*afero.OsFs); ok {
+		tmpPath, err := writeTempFile(config, path, data, perm)
+		if err != nil {
+			return err
+		}
+		err = os.Link(tmpPath, path)
+		config.AppFs.Remove(tmpPath)
+		if err == nil || os.IsExist(err) {
+			return err
+		}
+		// The filesystem does not support hard links, fall back to an exclusive create
+	}
+
+	file, err := config.AppFs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		config.AppFs.Remove(path)
+	}
+	return err
+}
+
+// writeTempFile writes data to a new hidden temporary file next to path, synced and with the given permissions,
+// and returns its path.
+func writeTempFile(config *Config, path string, data []byte, perm os.FileMode) (string, error) {
+	tmpFile, err := afero.TempFile(config.AppFs, filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+
+	err = config.AppFs.Chmod(tmpPath, perm)
+	if err == nil {
+		_, err = tmpFile.Write(data)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		config.AppFs.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
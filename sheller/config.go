@@ -1,8 +1,10 @@
 package sheller
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,6 +15,8 @@ import (
 )
 
 var DEFAULT_EXPIRY_BUFFER = 10 * time.Minute
+var DEFAULT_TOKEN_TTL = 1 * time.Hour
+var DEFAULT_AUTH_TIMEOUT = 5 * time.Minute
 var fs = afero.NewOsFs()
 
 // Config holds the configuration options for the Sheller library.
@@ -21,8 +25,21 @@ type Config struct {
 	Profile      string        // Name of the Akeyless CLI profile to use
 	AkeylessPath string        // Path to the .akeyless directory
 	ExpiryBuffer time.Duration // Buffer time before token expiry to trigger re-authentication
-	Debug        bool          // Debug flag to enable or disable debug logging
+	DefaultTTL   time.Duration // Token lifetime to assume when the Akeyless CLI does not report an expiry
+	LockTimeout  time.Duration // Maximum time to wait for another process to finish refreshing the token
+	AuthTimeout  time.Duration // Maximum time the Akeyless CLI may take to authenticate, zero disables the timeout
+	Debug        bool          // Debug flag to enable debug logging to stderr when no Logger is set
+	Logger       *slog.Logger  // Logger for library events, the library is silent when nil and Debug is off
 	AppFs        *afero.Afero  // Filesystem to use to enable mocking of the filesystem
+	Runner       CommandRunner // Runner used to invoke the Akeyless CLI, defaults to ExecCommandRunner
+	TokenStore   TokenStore    // Store used to cache tokens, defaults to a DirTokenStore for the .tmp_creds directory
+	AutoPrune    bool          // Prune tokens expired for longer than DEFAULT_PRUNE_GRACE_PERIOD after each refresh
+
+	// Setting one of these caches tokens in an EncryptedFileTokenStore when TokenStore is not set, see encryption.go
+	TokenKeyFile    string // Path to a file holding the token encryption key
+	TokenPassphrase string // Passphrase the token encryption key is derived from
+
+	encryptedStore *encryptedStoreCache // Store resolved from TokenKeyFile or TokenPassphrase, see encryptedTokenStore
 }
 
 // NewConfig creates a new Config instance with the provided parameters.
@@ -38,20 +55,23 @@ func NewConfig(cliPath, profile, akeylessPath string, expiryBuffer time.Duration
 		Profile:      profile,
 		AkeylessPath: akeylessPath,
 		ExpiryBuffer: expiryBuffer,
+		DefaultTTL:   DEFAULT_TOKEN_TTL,
+		LockTimeout:  DEFAULT_LOCK_TIMEOUT,
+		AuthTimeout:  DEFAULT_AUTH_TIMEOUT,
 		Debug:        debug,
 		AppFs:        afc,
+		Runner:       ExecCommandRunner{},
 	}
 }
 
 // NewConfigWithDefaults creates a new Config instance with default values.
 // It pulls the CLIPath from the system path and uses the "default" CLI profile.
 func NewConfigWithDefaults() *Config {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Println("Error:", err)
+	// Without a home directory the AkeylessPath is left empty and ValidateConfig reports the problem
+	akeylessHomeDir := ""
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		akeylessHomeDir = filepath.Join(homeDir, ".akeyless")
 	}
-	fmt.Println("Found Home Directory:", homeDir)
-	akeylessHomeDir := filepath.Join(homeDir, ".akeyless")
 
 	return NewConfig("", "default", akeylessHomeDir, 0, false)
 }
@@ -81,6 +101,43 @@ func LoadConfigFromEnv(config *Config) {
 	if config.ExpiryBuffer == 0 {
 		config.ExpiryBuffer = DEFAULT_EXPIRY_BUFFER
 	}
+	defaultTTLStr := os.Getenv("AKEYLESS_SHELLER_DEFAULT_TTL")
+	if defaultTTLStr != "" {
+		defaultTTL, err := time.ParseDuration(defaultTTLStr)
+		if err == nil {
+			config.DefaultTTL = defaultTTL
+		}
+	}
+
+	lockTimeoutStr := os.Getenv("AKEYLESS_SHELLER_LOCK_TIMEOUT")
+	if lockTimeoutStr != "" {
+		lockTimeout, err := time.ParseDuration(lockTimeoutStr)
+		if err == nil {
+			config.LockTimeout = lockTimeout
+		}
+	}
+
+	authTimeoutStr := os.Getenv("AKEYLESS_SHELLER_AUTH_TIMEOUT")
+	if authTimeoutStr != "" {
+		authTimeout, err := time.ParseDuration(authTimeoutStr)
+		if err == nil {
+			config.AuthTimeout = authTimeout
+		}
+	}
+
+	tokenKeyFile := os.Getenv("AKEYLESS_SHELLER_TOKEN_KEY_FILE")
+	if tokenKeyFile != "" {
+		config.TokenKeyFile = tokenKeyFile
+	}
+	tokenPassphrase := os.Getenv("AKEYLESS_SHELLER_TOKEN_PASSPHRASE")
+	if tokenPassphrase != "" {
+		config.TokenPassphrase = tokenPassphrase
+	}
+
+	autoPruneStr := os.Getenv("AKEYLESS_SHELLER_AUTO_PRUNE")
+	if autoPruneStr != "" {
+		config.AutoPrune = true
+	}
 
 	debugStr := os.Getenv("AKEYLESS_SHELLER_DEBUG")
 	if debugStr != "" {
@@ -94,7 +151,7 @@ func ValidateConfig(config *Config) error {
 	if config.CLIPath == "" {
 		akeylessFound := which.Which("akeyless")
 		if akeylessFound == "" {
-			return errors.New("the CLIPath is not set and akeyless is not in the system path")
+			return fmt.Errorf("%w: the CLIPath is not set and akeyless is not in the system path", ErrCLINotFound)
 		} else {
 			config.CLIPath = akeylessFound
 		}
@@ -105,38 +162,36 @@ func ValidateConfig(config *Config) error {
 		if cliProfileExistsError == nil {
 			config.Profile = "default"
 		} else {
-			return errors.New("the Akeyless CLI Profile name to use is not set and the default profile does not exist")
+			return fmt.Errorf("the Akeyless CLI Profile name to use is not set and the default profile does not exist: %w", cliProfileExistsError)
 		}
 	}
 
 	// Check if the CLIPath is an executable file
 	fileInfo, err := config.AppFs.Stat(config.CLIPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %w", ErrCLINotFound, err)
+		}
 		return err
 	}
 	if (fileInfo.Mode() & 0111) == 0 {
-		return errors.New("the CLIPath does not lead to an executable file")
+		return fmt.Errorf("%w: the CLIPath does not lead to an executable file", ErrCLINotFound)
 	}
 
 	// Check if the AkeylessPath property is not empty
 	if config.AkeylessPath == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			fmt.Println("Error:", err)
-		}
-
-		if config.Debug {
-			fmt.Println("**DEBUG** Home Directory:", homeDir)
+			config.logger().Warn("failed to determine the home directory", "error", err)
 		}
+		config.logger().Debug("using home directory", "path", homeDir)
 
 		akeylessHomeDir := filepath.Join(homeDir, ".akeyless")
 
 		if err := ValidateAkeylessHomeDirectoryExists(config); err != nil {
 			return err
 		}
-		if config.Debug {
-			fmt.Println("**DEBUG** Akeyless Home Directory exists")
-		}
+		config.logger().Debug("akeyless home directory exists", "path", akeylessHomeDir)
 		config.AkeylessPath = akeylessHomeDir
 	}
 
@@ -147,15 +202,24 @@ func ValidateConfig(config *Config) error {
 		return err
 	}
 
-	if config.Debug {
-		fmt.Println("**DEBUG** Loaded configuration:")
-		fmt.Println("CLIPath:", config.CLIPath)
-		fmt.Println("Profile:", config.Profile)
-		fmt.Println("AkeylessPath:", config.AkeylessPath)
-		fmt.Println("ExpiryBuffer:", config.ExpiryBuffer)
-		fmt.Println("Debug:", config.Debug)
+	// Report a broken encrypted token cache now rather than on the first token lookup
+	if config.TokenStore == nil && config.encryptionConfigured() {
+		if _, err := config.encryptedTokenStore(); err != nil {
+			return fmt.Errorf("failed to set up the encrypted token cache: %w", err)
+		}
 	}
 
+	config.logger().Debug("loaded configuration",
+		"cli_path", config.CLIPath,
+		"profile", config.Profile,
+		"akeyless_path", config.AkeylessPath,
+		"expiry_buffer", config.ExpiryBuffer,
+		"default_ttl", config.DefaultTTL,
+		"lock_timeout", config.LockTimeout,
+		"auth_timeout", config.AuthTimeout,
+		"encrypted_token_cache", config.encryptionConfigured(),
+	)
+
 	return nil
 }
 
@@ -188,25 +252,43 @@ func ValidateAkeylessCliProfileExists(config *Config, name string) error {
 	profileFilePath := filepath.Join(config.AkeylessPath, "profiles", name+".toml")
 	fileInfo, err := config.AppFs.Stat(profileFilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return errors.New("the profile file " + profileFilePath + " does not exist")
-		}
-		if os.IsPermission(err) {
-			return errors.New("the profile file " + profileFilePath + " is not readable")
-		}
-		return err
+		return newProfileError(name, profileFilePath, err)
 	}
 	// Check if the profile file is a directory
 	if fileInfo.IsDir() {
-		return errors.New("the profile file " + profileFilePath + " is a directory")
+		return &ProfileError{Name: name, Path: profileFilePath, Err: fmt.Errorf("%w: the path is a directory", ErrProfileUnreadable)}
 	}
 	// Check if the profile file is readable
 	if (fileInfo.Mode() & 0400) == 0 {
-		return errors.New("the profile file " + profileFilePath + " is not readable")
+		return &ProfileError{Name: name, Path: profileFilePath, Err: ErrProfileUnreadable}
 	}
 	return nil
 }
 
+// commandRunner returns the configured CommandRunner, falling back to ExecCommandRunner.
+func (config *Config) commandRunner() CommandRunner {
+	if config.Runner == nil {
+		return ExecCommandRunner{}
+	}
+	return config.Runner
+}
+
+// defaultTokenTTL returns the configured DefaultTTL, falling back to DEFAULT_TOKEN_TTL.
+func (config *Config) defaultTokenTTL() time.Duration {
+	if config.DefaultTTL <= 0 {
+		return DEFAULT_TOKEN_TTL
+	}
+	return config.DefaultTTL
+}
+
+// lockTimeout returns the configured LockTimeout, falling back to DEFAULT_LOCK_TIMEOUT.
+func (config *Config) lockTimeout() time.Duration {
+	if config.LockTimeout <= 0 {
+		return DEFAULT_LOCK_TIMEOUT
+	}
+	return config.LockTimeout
+}
+
 // InitializeLibrary initializes the Sheller library with the provided configuration.
 // It loads the configuration from environment variables and validates it.
 func InitializeLibrary(config *Config) error {
@@ -225,6 +307,11 @@ func InitializeLibrary(config *Config) error {
 // InitializeAndGetToken initializes the library, gets the profile, and retrieves the token.
 // It returns the retrieved token or an error if something went wrong.
 func InitializeAndGetToken(config *Config) (*Token, error) {
+	return InitializeAndGetTokenContext(context.Background(), config)
+}
+
+// InitializeAndGetTokenContext is like InitializeAndGetToken but stops waiting for the token when ctx is done.
+func InitializeAndGetTokenContext(ctx context.Context, config *Config) (*Token, error) {
 	err := InitializeLibrary(config)
 	if err != nil {
 		return nil, err
@@ -233,7 +320,7 @@ func InitializeAndGetToken(config *Config) (*Token, error) {
 	if errProfile != nil {
 		return nil, errProfile
 	}
-	token, err := GetToken(profile, config)
+	token, err := GetTokenContext(ctx, profile, config)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +117,15 @@ func TestValidateConfig(t *testing.T) {
 	if err == nil {
 		t.Errorf("Expected error, but got none")
 	}
+
+	// Test case 5: A broken encrypted token cache is reported without choosing the TokenStore
+	config1.TokenKeyFile = "/path/to/missing.key"
+	if err := ValidateConfig(config1); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+	if config1.TokenStore != nil {
+		t.Errorf("Expected TokenStore to be left unset, but got %T", config1.TokenStore)
+	}
 }
 
 func TestValidateAkeylessHomeDirectoryExists(t *testing.T) {
@@ -0,0 +1,245 @@
+package sheller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DEFAULT_PASSPHRASE_ITERATIONS is the number of PBKDF2-HMAC-SHA256 iterations used to derive the token
+// encryption key from Config.TokenPassphrase. Changing it changes the key, so previously cached tokens become cache misses.
+var DEFAULT_PASSPHRASE_ITERATIONS = 600000
+
+// passphraseSaltSize is the size in bytes of the random salt used to derive a key from a passphrase.
+const passphraseSaltSize = 16
+
+// passphraseSaltName is the name of the file holding the salt, inside the encrypted token directory.
+const passphraseSaltName = ".salt"
+
+// encryptionConfigured reports whether the config asks for the encrypted token cache.
+func (config *Config) encryptionConfigured() bool {
+	return config.TokenKeyFile != "" || config.TokenPassphrase != ""
+}
+
+// encryptedStoreCache holds the EncryptedFileTokenStore resolved for a Config, together with the settings it was
+// resolved from. It is replaced rather than modified, so a copy of the Config that shares it never sees a change.
+type encryptedStoreCache struct {
+	config     *Config
+	keyFile    string
+	passphrase string
+	dir        string
+	iterations int
+	store      *EncryptedFileTokenStore
+}
+
+// resolvedFor reports whether the cached store was resolved for the config with its current settings.
+func (c *encryptedStoreCache) resolvedFor(config *Config, dir string) bool {
+	return c != nil && c.config == config && c.keyFile == config.TokenKeyFile && c.passphrase == config.TokenPassphrase &&
+		c.dir == dir && c.iterations == DEFAULT_PASSPHRASE_ITERATIONS
+}
+
+// encryptedStoreMu guards Config.encryptedStore for every Config.
+var encryptedStoreMu sync.Mutex
+
+// encryptedTokenStore returns the EncryptedFileTokenStore described by TokenKeyFile or TokenPassphrase. The store
+// is resolved once and reused until one of those settings changes, so the key file is not read and the key is not
+// derived again on every token lookup. Errors are not cached, so a missing key file or salt that appears later
+// is picked up by the next call.
+func (config *Config) encryptedTokenStore() (*EncryptedFileTokenStore, error) {
+	encryptedStoreMu.Lock()
+	defer encryptedStoreMu.Unlock()
+
+	dir := filepath.Join(config.AkeylessPath, encryptedTokenDirName)
+	if cache := config.encryptedStore; cache.resolvedFor(config, dir) {
+		return cache.store, nil
+	}
+
+	store, err := newConfiguredTokenStore(config)
+	if err != nil {
+		return nil, err
+	}
+	config.encryptedStore = &encryptedStoreCache{
+		config:     config,
+		keyFile:    config.TokenKeyFile,
+		passphrase: config.TokenPassphrase,
+		dir:        dir,
+		iterations: DEFAULT_PASSPHRASE_ITERATIONS,
+		store:      store,
+	}
+	return store, nil
+}
+
+// newConfiguredTokenStore creates the EncryptedFileTokenStore described by Config.TokenKeyFile or Config.TokenPassphrase.
+func newConfiguredTokenStore(config *Config) (*EncryptedFileTokenStore, error) {
+	dir := filepath.Join(config.AkeylessPath, encryptedTokenDirName)
+
+	var key []byte
+	var err error
+	switch {
+	case config.TokenKeyFile != "" && config.TokenPassphrase != "":
+		return nil, errors.New("only one of TokenKeyFile and TokenPassphrase may be set")
+	case config.TokenKeyFile != "":
+		key, err = LoadTokenKeyFile(config.TokenKeyFile, config)
+	default:
+		var salt []byte
+		if salt, err = loadPassphraseSalt(dir, config); err == nil {
+			key = deriveTokenKey([]byte(config.TokenPassphrase), salt, DEFAULT_PASSPHRASE_ITERATIONS)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEncryptedFileTokenStore(dir, key, config)
+}
+
+// LoadTokenKeyFile reads a token encryption key from a key file holding EncryptedTokenKeySize raw bytes or their
+// hex encoding. The key file must not be accessible to the group or to other users.
+func LoadTokenKeyFile(path string, config *Config) ([]byte, error) {
+	info, err := config.AppFs.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("the token key file %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("the token key file %s is a directory", path)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("the token key file %s must only be accessible by its owner, but has mode %04o", path, perm)
+	}
+
+	data, err := config.AppFs.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("the token key file %s: %w", path, err)
+	}
+	if len(data) == EncryptedTokenKeySize {
+		return data, nil
+	}
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil && len(key) == EncryptedTokenKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the token key file %s must hold %d bytes or their hex encoding", path, EncryptedTokenKeySize)
+}
+
+// GenerateTokenKeyFile writes a new random token encryption key to path, hex encoded and readable only by its owner.
+// It fails if the file already exists, so an existing key, and the tokens encrypted with it, are never lost.
+func GenerateTokenKeyFile(path string, config *Config) error {
+	key := make([]byte, EncryptedTokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := config.AppFs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := config.AppFs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// passphraseSaltReadAttempts is how many times a salt file of the wrong size is read again before it is
+// reported as corrupt, giving another process on a filesystem without hard links time to finish writing it.
+const passphraseSaltReadAttempts = 10
+
+// loadPassphraseSalt returns the salt stored in dir, creating it when the directory has none yet.
+// A new salt is published complete and never replaces one another process created first.
+func loadPassphraseSalt(dir string, config *Config) ([]byte, error) {
+	path := filepath.Join(dir, passphraseSaltName)
+	for attempt := 1; ; attempt++ {
+		salt, err := config.AppFs.ReadFile(path)
+		if err == nil && len(salt) == passphraseSaltSize {
+			return salt, nil
+		}
+		if err == nil {
+			if attempt < passphraseSaltReadAttempts {
+				time.Sleep(lockPollInterval)
+				continue
+			}
+			return nil, fmt.Errorf("the salt file %s is corrupt, remove it together with the encrypted tokens", path)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		salt = make([]byte, passphraseSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if err := config.AppFs.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		err = writeFileExclusive(config, path, salt, 0600)
+		if err == nil {
+			return salt, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// Another process created the salt first, use its salt
+	}
+}
+
+// deriveTokenKey derives a token encryption key from a passphrase with PBKDF2-HMAC-SHA256 (RFC 8018).
+func deriveTokenKey(passphrase, salt []byte, iterations int) []byte {
+	return pbkdf2.Key(passphrase, salt, iterations, EncryptedTokenKeySize, sha256.New)
+}
+
+// MigrationReport lists what MigratePlaintextTokens did with each plaintext token file.
+type MigrationReport struct {
+	Migrated []string // Files that were encrypted into the token store and removed
+	Skipped  []string // Files that were left in place, such as tokens cached by the Akeyless CLI itself
+}
+
+// MigratePlaintextTokens moves the tokens that sheller cached as plaintext in .tmp_creds into the configured
+// EncryptedFileTokenStore and removes the plaintext files. Tokens cached by the Akeyless CLI itself do not
+// record the profile they belong to and are left for the CLI; expired tokens are dropped rather than migrated.
+func MigratePlaintextTokens(config *Config) (*MigrationReport, error) {
+	store, err := config.tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, ok := store.(*EncryptedFileTokenStore)
+	if !ok {
+		return nil, errors.New("the encrypted token cache is not configured, set TokenKeyFile or TokenPassphrase")
+	}
+
+	candidates, err := scanTokenCache(&Profile{}, config, time.Now())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &MigrationReport{}, nil
+		}
+		return nil, err
+	}
+
+	report := &MigrationReport{}
+	for _, candidate := range candidates {
+		if candidate.Err != nil || candidate.Token.ProfileFingerprint == "" {
+			report.Skipped = append(report.Skipped, candidate.Path)
+			continue
+		}
+		if candidate.Valid {
+			if err := encrypted.put(candidate.Token.ProfileFingerprint, candidate.Token); err != nil {
+				return report, err
+			}
+		}
+		if err := config.AppFs.Remove(candidate.Path); err != nil {
+			return report, err
+		}
+		config.logger().Info("migrated plaintext token to the encrypted token cache", "path", candidate.Path, "expired", !candidate.Valid)
+		report.Migrated = append(report.Migrated, candidate.Path)
+	}
+	return report, nil
+}
@@ -0,0 +1,257 @@
+package sheller
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestDeriveTokenKey(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256 from RFC 7914, section 11
+	tests := []struct {
+		passphrase string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+	for _, tt := range tests {
+		key := deriveTokenKey([]byte(tt.passphrase), []byte(tt.salt), tt.iterations)
+		if hex.EncodeToString(key) != tt.expected {
+			t.Errorf("Expected the key for %q to be %s, but got %x", tt.passphrase, tt.expected, key)
+		}
+	}
+}
+
+func TestLoadTokenKeyFile(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	key := newMockTokenKey()
+
+	// Test case 1: Raw and hex encoded keys are accepted
+	afero.WriteFile(mockFs, "/keys/raw", key, 0600)
+	afero.WriteFile(mockFs, "/keys/hex", []byte(hex.EncodeToString(key)+"\n"), 0400)
+	for _, path := range []string{"/keys/raw", "/keys/hex"} {
+		loaded, err := LoadTokenKeyFile(path, config)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !bytes.Equal(loaded, key) {
+			t.Errorf("Expected the key from %s to be %x, but got %x", path, key, loaded)
+		}
+	}
+
+	// Test case 2: A key file readable by other users is rejected
+	afero.WriteFile(mockFs, "/keys/open", key, 0644)
+	if _, err := LoadTokenKeyFile("/keys/open", config); err == nil || !strings.Contains(err.Error(), "0644") {
+		t.Errorf("Expected an error about the file mode, but got %v", err)
+	}
+
+	// Test case 3: A key of the wrong size is rejected
+	afero.WriteFile(mockFs, "/keys/short", []byte("abcd"), 0600)
+	if _, err := LoadTokenKeyFile("/keys/short", config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 4: A generated key file can be loaded and is never overwritten
+	if err := GenerateTokenKeyFile("/keys/generated", config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := LoadTokenKeyFile("/keys/generated", config); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+	if err := GenerateTokenKeyFile("/keys/generated", config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
+
+func TestEncryptedTokenCache(t *testing.T) {
+	iterations := DEFAULT_PASSPHRASE_ITERATIONS
+	DEFAULT_PASSPHRASE_ITERATIONS = 1000
+	defer func() { DEFAULT_PASSPHRASE_ITERATIONS = iterations }()
+
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-secret","expiry":1900000000}`}}}
+	config.TokenPassphrase = "correct horse battery staple"
+	profile := newMockProfile()
+
+	// Test case 1: The token is cached encrypted and never written to .tmp_creds
+	if _, err := GetToken(profile, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	afero.Walk(mockFs, "/path/to/akeyless", func(path string, info os.FileInfo, err error) error {
+		if data, _ := afero.ReadFile(mockFs, path); bytes.Contains(data, []byte("t-secret")) {
+			t.Errorf("Expected no plaintext token on disk, but found it in %s", path)
+		}
+		return nil
+	})
+
+	// Test case 2: Another config with the same passphrase reads the cached token
+	other := *config
+	other.Runner = &FakeCommandRunner{}
+	token, err := CheckForExistingToken(profile, &other)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-secret" {
+		t.Errorf("Expected Token to be 't-secret', but got %s", token.Token)
+	}
+
+	// Test case 3: A different passphrase cannot decrypt the token, which is a cache miss
+	other.TokenPassphrase = "wrong passphrase"
+	if _, err := CheckForExistingToken(profile, &other); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+
+	// Test case 4: Setting both a key file and a passphrase is an error
+	other.TokenKeyFile = "/keys/key"
+	if _, err := CheckForExistingToken(profile, &other); err == nil || errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected a configuration error, but got %v", err)
+	}
+}
+
+func TestEncryptedTokenStoreIsResolvedOnce(t *testing.T) {
+	iterations := DEFAULT_PASSPHRASE_ITERATIONS
+	DEFAULT_PASSPHRASE_ITERATIONS = 1000
+	defer func() { DEFAULT_PASSPHRASE_ITERATIONS = iterations }()
+
+	config, mockFs := newMockTokenConfig(t)
+	config.TokenPassphrase = "correct horse battery staple"
+
+	// Test case 1: The key is derived once and the store reused for every lookup
+	first, err := config.tokenStore()
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if second, _ := config.tokenStore(); second != first {
+		t.Errorf("Expected the same store to be reused")
+	}
+
+	// Test case 2: Changing the passphrase or switching to a key file is honoured
+	config.TokenPassphrase = "another passphrase"
+	changed, err := config.tokenStore()
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if changed == first {
+		t.Errorf("Expected a new store after changing the passphrase")
+	}
+	config.TokenPassphrase = ""
+	config.TokenKeyFile = "/keys/key"
+	if _, err := config.tokenStore(); err == nil {
+		t.Errorf("Expected an error for the missing key file, but got none")
+	}
+	// Errors are not cached, so the key file is picked up once it exists
+	afero.WriteFile(mockFs, "/keys/key", newMockTokenKey(), 0600)
+	if _, err := config.tokenStore(); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	// Test case 3: A copy of the config resolves its own store
+	other := *config
+	other.AppFs = &afero.Afero{Fs: afero.NewMemMapFs()}
+	if _, err := other.tokenStore(); err == nil {
+		t.Errorf("Expected an error for the key file missing from the other filesystem, but got none")
+	}
+}
+
+func TestLoadPassphraseSalt(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	dir := "/path/to/akeyless/.sheller_creds"
+
+	// Test case 1: A new salt is created once and then reused
+	salt, err := loadPassphraseSalt(dir, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if again, _ := loadPassphraseSalt(dir, config); !bytes.Equal(again, salt) {
+		t.Errorf("Expected the salt to be reused")
+	}
+
+	// Test case 2: A salt file still being written by another process is waited for
+	mockFs.Remove(dir + "/.salt")
+	afero.WriteFile(mockFs, dir+"/.salt", nil, 0600)
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		afero.WriteFile(mockFs, dir+"/.salt", salt, 0600)
+	}()
+	if loaded, err := loadPassphraseSalt(dir, config); err != nil || !bytes.Equal(loaded, salt) {
+		t.Errorf("Expected the salt written by the other process, but got %x, %v", loaded, err)
+	}
+
+	// Test case 3: Concurrent callers all end up with the same salt
+	mockFs.Remove(dir + "/.salt")
+	var wg sync.WaitGroup
+	salts := make([][]byte, 10)
+	for i := range salts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			salts[i], _ = loadPassphraseSalt(dir, config)
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range salts {
+		if len(s) != passphraseSaltSize || !bytes.Equal(s, salts[0]) {
+			t.Errorf("Expected every caller to get the same salt, but got %x and %x", s, salts[0])
+		}
+	}
+}
+
+func TestMigratePlaintextTokens(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	afero.WriteFile(mockFs, "/keys/key", newMockTokenKey(), 0600)
+	profile := newMockProfile()
+
+	// A token cached by sheller, an expired one and one cached by the Akeyless CLI
+	token := &Token{AccessID: "p-123", Token: "t-sheller", Expiry: time.Now().Add(time.Hour)}
+	setProfileIdentity(token, profile)
+	if err := SaveToken(profile, token, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	expiredProfile := &Profile{Name: "expired", AccessID: "p-456", AccessKey: "key"}
+	expired := &Token{AccessID: "p-456", Token: "t-expired", Expiry: time.Now().Add(-time.Hour)}
+	setProfileIdentity(expired, expiredProfile)
+	SaveToken(expiredProfile, expired, config)
+	writeMockTokenFile(t, mockFs, "cli-written", "p-789", "t-cli", time.Now().Add(time.Hour))
+
+	// Test case 1: Migrating without the encrypted cache configured fails
+	if _, err := MigratePlaintextTokens(config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 2: Tokens cached by sheller are moved into the encrypted store and the CLI's token is left alone
+	config.TokenKeyFile = "/keys/key"
+	report, err := MigratePlaintextTokens(config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(report.Migrated) != 2 || len(report.Skipped) != 1 || !strings.HasSuffix(report.Skipped[0], "cli-written") {
+		t.Errorf("Expected 2 migrated files and the CLI file skipped, but got %+v", report)
+	}
+	for _, path := range report.Migrated {
+		if exists, _ := afero.Exists(mockFs, path); exists {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	migrated, err := CheckForExistingToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if migrated.Token != "t-sheller" {
+		t.Errorf("Expected Token to be 't-sheller', but got %s", migrated.Token)
+	}
+	store, _ := config.tokenStore()
+	if tokens, _ := store.List(); len(tokens) != 1 {
+		t.Errorf("Expected only the valid token to be migrated, but got %v", tokens)
+	}
+}
@@ -0,0 +1,81 @@
+package sheller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned by sheller. They are usually wrapped with more context,
+// so compare against them with errors.Is rather than ==.
+var (
+	// ErrProfileNotFound is returned when the profile file does not exist.
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrProfileUnreadable is returned when the profile file exists but cannot be read.
+	ErrProfileUnreadable = errors.New("profile is not readable")
+	// ErrProfileExists is returned by CreateProfile when the profile file already exists.
+	ErrProfileExists = errors.New("profile already exists")
+	// ErrProfileInvalid is returned when a profile lacks fields its access type needs. The error is a *ProfileValidationError.
+	ErrProfileInvalid = errors.New("profile is not valid")
+	// ErrCLINotFound is returned when the Akeyless CLI cannot be found or is not executable.
+	ErrCLINotFound = errors.New("akeyless CLI not found")
+	// ErrNoCachedToken is returned when the token cache holds no valid token for the profile.
+	ErrNoCachedToken = errors.New("no valid token found")
+	// ErrAuthFailed is returned when the Akeyless CLI fails to authenticate. The error is usually an *AuthError.
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrAuthTimeout is returned when the Akeyless CLI does not finish authenticating within Config.AuthTimeout.
+	ErrAuthTimeout = errors.New("timed out waiting for the Akeyless CLI to authenticate")
+	// ErrRefreshLockTimeout is returned when the token refresh lock could not be acquired within Config.LockTimeout.
+	ErrRefreshLockTimeout = errors.New("timed out waiting for the token refresh lock")
+)
+
+// ProfileError describes a problem with a specific Akeyless CLI profile file.
+// Err is typically ErrProfileNotFound or ErrProfileUnreadable, optionally wrapping the underlying cause.
+type ProfileError struct {
+	Name string // Profile name
+	Path string // Path of the profile file
+	Err  error
+}
+
+func (e *ProfileError) Error() string {
+	return fmt.Sprintf("the profile file %s: %v", e.Path, e.Err)
+}
+
+func (e *ProfileError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError describes a failed "akeyless auth" invocation. It matches ErrAuthFailed with errors.Is.
+type AuthError struct {
+	Profile  string // Name of the profile used to authenticate
+	Command  string // Command line that was run, with secret flag values redacted
+	ExitCode int    // Exit code of the CLI, -1 if it could not be run
+	Message  string // Error message reported by the CLI, taken from its JSON error output when available
+	Stderr   string // Standard error output of the CLI
+	Err      error  // Underlying cause, if any
+}
+
+func (e *AuthError) Error() string {
+	msg := fmt.Sprintf("authentication with profile %q failed", e.Profile)
+	if e.ExitCode >= 0 {
+		msg += fmt.Sprintf(": the Akeyless CLI exited with status %d", e.ExitCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Command != "" {
+		msg += " (command: " + e.Command + ")"
+	}
+	return msg
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrAuthFailed.
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
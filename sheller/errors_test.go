@@ -0,0 +1,210 @@
+package sheller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestProfileErrors(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	config := NewConfig("/path/to/cli", "default", "/path/to/akeyless", 0, false)
+	config.AppFs = &afero.Afero{Fs: mockFs}
+	mockFs.MkdirAll("/path/to/akeyless/profiles/directory.toml", 0755)
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/unreadable.toml", []byte{}, 0000)
+
+	// Test case 1: A missing profile
+	err := ValidateAkeylessCliProfileExists(config, "missing")
+	if !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, but got %v", err)
+	}
+	var profileErr *ProfileError
+	if !errors.As(err, &profileErr) || profileErr.Name != "missing" || profileErr.Path != "/path/to/akeyless/profiles/missing.toml" {
+		t.Errorf("Expected a ProfileError for the missing profile, but got %#v", err)
+	}
+	if _, err := GetProfile("missing", config); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, but got %v", err)
+	}
+
+	// Test case 2: A profile that is a directory or not readable
+	if err := ValidateAkeylessCliProfileExists(config, "directory"); !errors.Is(err, ErrProfileUnreadable) {
+		t.Errorf("Expected ErrProfileUnreadable, but got %v", err)
+	}
+	if err := ValidateAkeylessCliProfileExists(config, "unreadable"); !errors.Is(err, ErrProfileUnreadable) {
+		t.Errorf("Expected ErrProfileUnreadable, but got %v", err)
+	}
+}
+
+func TestCLINotFoundErrors(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	config := NewConfig("/path/to/cli", "default", "/path/to/akeyless", 0, false)
+	config.AppFs = &afero.Afero{Fs: mockFs}
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/default.toml", []byte{}, 0600)
+
+	// Test case 1: The CLI does not exist
+	if err := ValidateConfig(config); !errors.Is(err, ErrCLINotFound) {
+		t.Errorf("Expected ErrCLINotFound, but got %v", err)
+	}
+
+	// Test case 2: The CLI is not executable
+	afero.WriteFile(mockFs, "/path/to/cli", []byte{}, 0644)
+	if err := ValidateConfig(config); !errors.Is(err, ErrCLINotFound) {
+		t.Errorf("Expected ErrCLINotFound, but got %v", err)
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	profile := newMockProfile()
+
+	// Test case 1: No cached token, with and without a cache directory
+	config1, mockFs1 := newMockTokenConfig(t)
+	if _, err := CheckForExistingToken(profile, config1); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+	mockFs1.RemoveAll("/path/to/akeyless/.tmp_creds")
+	if _, err := CheckForExistingToken(profile, config1); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+
+	// Test case 2: The CLI exits with an error
+	config2, mockFs2 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs2, "default", "[default]\naccess_id = 'p-123'\n")
+	config2.Runner = &FakeCommandRunner{Results: []FakeResult{{Stderr: "access denied", ExitCode: 2}}}
+	_, err := GetToken(profile, config2)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed, but got %v", err)
+	}
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected an AuthError, but got %#v", err)
+	}
+	if authErr.Profile != "default" || authErr.ExitCode != 2 || authErr.Stderr != "access denied" {
+		t.Errorf("Expected AuthError details to be captured, but got %+v", authErr)
+	}
+
+	// Test case 3: The CLI cannot be run at all
+	config3, mockFs3 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs3, "default", "[default]\naccess_id = 'p-123'\n")
+	cause := errors.New("exec format error")
+	config3.Runner = &FakeCommandRunner{Results: []FakeResult{{Err: cause}}}
+	_, err = GetToken(profile, config3)
+	if !errors.Is(err, ErrAuthFailed) || !errors.Is(err, cause) {
+		t.Errorf("Expected ErrAuthFailed wrapping the cause, but got %v", err)
+	}
+
+	// Test case 4: The CLI is missing
+	config4, mockFs4 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs4, "default", "[default]\naccess_id = 'p-123'\n")
+	mockFs4.Remove("/path/to/cli")
+	if _, err := ShellOutForNewToken(profile, config4); !errors.Is(err, ErrCLINotFound) {
+		t.Errorf("Expected ErrCLINotFound, but got %v", err)
+	}
+
+	// Test case 5: The runner cannot find the CLI or the CLI cannot be checked
+	afero.WriteFile(mockFs4, "/path/to/cli", []byte{}, 0755)
+	for _, cause := range []error{exec.ErrNotFound, &os.PathError{Op: "fork/exec", Path: "/path/to/cli", Err: os.ErrNotExist}} {
+		config4.Runner = &FakeCommandRunner{Results: []FakeResult{{Err: cause}}}
+		_, err = ShellOutForNewToken(profile, config4)
+		if !errors.Is(err, ErrCLINotFound) || errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Expected only ErrCLINotFound, but got %v", err)
+		}
+	}
+	config4.AppFs = &afero.Afero{Fs: &statErrorFs{Fs: mockFs4}}
+	if _, err := ShellOutForNewToken(profile, config4); !errors.Is(err, ErrCLINotFound) || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected ErrCLINotFound wrapping the stat error, but got %v", err)
+	}
+
+	// Test case 6: Timeouts are distinct from authentication failures
+	config5, mockFs5 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs5, "default", "[default]\naccess_id = 'p-123'\n")
+	config5.AuthTimeout = 10 * time.Millisecond
+	config5.Runner = &FakeCommandRunner{Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	_, err = GetToken(profile, config5)
+	if !errors.Is(err, ErrAuthTimeout) || errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected only ErrAuthTimeout, but got %v", err)
+	}
+}
+
+func TestAuthErrorDetails(t *testing.T) {
+	tests := []struct {
+		name            string
+		result          FakeResult
+		expectedMessage string
+	}{
+		{
+			name:            "JSON error on stderr",
+			result:          FakeResult{Stderr: `{"error":"access denied"}`, ExitCode: 1},
+			expectedMessage: "access denied",
+		},
+		{
+			name:            "nested JSON error on stdout",
+			result:          FakeResult{Stdout: `{"error":{"message":"gateway unreachable"}}`, Stderr: "\n", ExitCode: 1},
+			expectedMessage: "gateway unreachable",
+		},
+		{
+			name:            "plain text stderr",
+			result:          FakeResult{Stderr: "Error: unknown flag `foo'\n", ExitCode: 1},
+			expectedMessage: "Error: unknown flag `foo'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, mockFs := newMockTokenConfig(t)
+			writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\naccess_key = 'super-secret'\n")
+			config.Runner = &FakeCommandRunner{Results: []FakeResult{tt.result}}
+
+			profile, err := GetProfile("default", config)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			_, err = ShellOutForNewToken(profile, config)
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("Expected an AuthError, but got %#v", err)
+			}
+			if authErr.Message != tt.expectedMessage {
+				t.Errorf("Expected Message to be %q, but got %q", tt.expectedMessage, authErr.Message)
+			}
+			if authErr.Command != "/path/to/cli auth --access-id p-123 --access-key ***** --json" {
+				t.Errorf("Expected the redacted command line, but got %q", authErr.Command)
+			}
+			if strings.Contains(err.Error(), "super-secret") {
+				t.Errorf("Expected the error message to be redacted, but got %q", err.Error())
+			}
+			if !strings.Contains(err.Error(), tt.expectedMessage) {
+				t.Errorf("Expected the error message to contain %q, but got %q", tt.expectedMessage, err.Error())
+			}
+		})
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{"akeyless", "auth", "--access-id", "p-123", "--access-key", "secret", "--jwt=eyJ", "--uid-token", "u-1", "--password"}
+	expected := []string{"akeyless", "auth", "--access-id", "p-123", "--access-key", "*****", "--jwt=*****", "--uid-token", "*****", "--password"}
+	redacted := redactArgs(args)
+	if strings.Join(redacted, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %q, but got %q", expected, redacted)
+	}
+	if args[5] != "secret" {
+		t.Errorf("Expected the original argv to be left untouched")
+	}
+}
+
+// statErrorFs is a filesystem on which every Stat fails with a permission error.
+type statErrorFs struct {
+	afero.Fs
+}
+
+func (s *statErrorFs) Stat(name string) (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrPermission}
+}
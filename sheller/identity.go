@@ -0,0 +1,83 @@
+package sheller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// identityAccessType returns the access type "akeyless auth" uses for the profile.
+func (p *Profile) identityAccessType() string {
+	if p.AccessType == "" {
+		return defaultAccessType
+	}
+	return p.AccessType
+}
+
+// identityGatewayURL returns the gateway URL of the profile in a form that ignores a trailing slash.
+func (p *Profile) identityGatewayURL() string {
+	return strings.TrimRight(p.GatewayURL, "/")
+}
+
+// Fingerprint returns a hash of the profile settings that decide which identity a token is issued for,
+// such as the access ID, access type, gateway URL and sub-claim settings. Credential values are left out, so
+// the fingerprint does not reveal them and stays the same when a credential is rotated. The one exception is a
+// universal identity profile without an access ID, whose UID token is the only thing that identifies it, so a
+// hash of the token is included instead.
+func (p *Profile) Fingerprint() string {
+	settings := p.Settings()
+	settings["access_type"] = p.identityAccessType()
+	if _, ok := settings["gateway_url"]; ok {
+		settings["gateway_url"] = p.identityGatewayURL()
+	}
+	if p.AccessID == "" && p.UIDToken != "" {
+		sum := sha256.Sum256([]byte(p.UIDToken))
+		settings["uid_token_sha256"] = hex.EncodeToString(sum[:])
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key, value := range settings {
+		// An empty setting authenticates the same way as a missing one
+		if value == nil || value == "" || value == false || isSensitiveKey(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%v\n", key, settings[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// setProfileIdentity records the identity of the profile a token was issued for on the token.
+func setProfileIdentity(token *Token, profile *Profile) {
+	token.AccessType = profile.identityAccessType()
+	token.GatewayURL = profile.identityGatewayURL()
+	token.ProfileFingerprint = profile.Fingerprint()
+}
+
+// tokenMatchesProfile reports whether a cached token was issued for the profile. Tokens written by sheller
+// carry the identity of their profile and must match it fully. Tokens written by the Akeyless CLI only carry
+// an access ID, so for them the access ID alone decides, and the same goes for any identity field a token lacks.
+// A profile without an access ID, such as a universal identity profile, gets its tokens stamped with the access
+// ID the CLI reports, so its own tokens are recognised by their fingerprint alone.
+func tokenMatchesProfile(token *Token, profile *Profile) bool {
+	if token.AccessID != profile.AccessID && (profile.AccessID != "" || token.ProfileFingerprint == "") {
+		return false
+	}
+	if token.AccessType != "" && token.AccessType != profile.identityAccessType() {
+		return false
+	}
+	if token.GatewayURL != "" && token.GatewayURL != profile.identityGatewayURL() {
+		return false
+	}
+	if token.ProfileFingerprint != "" && token.ProfileFingerprint != profile.Fingerprint() {
+		return false
+	}
+	return true
+}
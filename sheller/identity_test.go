@@ -0,0 +1,125 @@
+package sheller
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestProfileFingerprint(t *testing.T) {
+	base := &Profile{Name: "default", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://gw.example.com"}
+	fingerprint := base.Fingerprint()
+
+	// Test case 1: Credentials, the profile name and an implicit access type do not change the fingerprint
+	same := []*Profile{
+		{Name: "default", AccessID: "p-123", AccessKey: "rotated-key", GatewayURL: "https://gw.example.com"},
+		{Name: "renamed", AccessID: "p-123", AccessType: "access_key", GatewayURL: "https://gw.example.com/"},
+	}
+	for _, profile := range same {
+		if profile.Fingerprint() != fingerprint {
+			t.Errorf("Expected %v to have the fingerprint %s, but got %s", profile, fingerprint, profile.Fingerprint())
+		}
+	}
+
+	// Test case 2: Settings that change the identity change the fingerprint
+	different := []*Profile{
+		{Name: "default", AccessID: "p-456", AccessKey: "key", GatewayURL: "https://gw.example.com"},
+		{Name: "default", AccessID: "p-123", AccessType: "k8s", GatewayURL: "https://gw.example.com"},
+		{Name: "default", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://other-gw.example.com"},
+		{Name: "default", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://gw.example.com", Extra: map[string]interface{}{"sub_claims": "team=a"}},
+	}
+	for _, profile := range different {
+		if profile.Fingerprint() == fingerprint {
+			t.Errorf("Expected %v to have a different fingerprint", profile)
+		}
+	}
+
+	// Test case 3: The fingerprint does not contain the credentials
+	if strings.Contains(fingerprint, "key") {
+		t.Errorf("Expected the fingerprint to be a hash, but got %s", fingerprint)
+	}
+
+	// Test case 4: Universal identity profiles without an access ID are told apart by their UID token
+	uidA := &Profile{Name: "uid", AccessType: "universal_identity", UIDToken: "u-a"}
+	uidB := &Profile{Name: "uid", AccessType: "universal_identity", UIDToken: "u-b"}
+	if uidA.Fingerprint() == uidB.Fingerprint() {
+		t.Errorf("Expected profiles with different UID tokens to have different fingerprints")
+	}
+}
+
+func TestCheckForExistingTokenMatchesProfileIdentity(t *testing.T) {
+	gatewayA := &Profile{Name: "gw-a", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://a.example.com"}
+	gatewayB := &Profile{Name: "gw-b", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://b.example.com"}
+
+	// Test case 1: A token saved for one profile is not reused by another profile with the same access ID
+	config, mockFs := newMockTokenConfig(t)
+	token := &Token{AccessID: "p-123", Token: "t-gateway-a", Expiry: time.Now().Add(time.Hour).Truncate(time.Second)}
+	setProfileIdentity(token, gatewayA)
+	if err := SaveToken(gatewayA, token, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	found, err := CheckForExistingToken(gatewayA, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if found.Token != "t-gateway-a" || found.GatewayURL != "https://a.example.com" || found.ProfileFingerprint != gatewayA.Fingerprint() {
+		t.Errorf("Expected the saved token with its identity, but got %+v", found.Expose())
+	}
+	if _, err := CheckForExistingToken(gatewayB, config); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+
+	// Test case 2: A token written by the Akeyless CLI only carries the access ID and matches on it alone
+	writeMockTokenFile(t, mockFs, "cli-written", "p-123", "t-cli", time.Now().Add(30*time.Minute))
+	found, err = CheckForExistingToken(gatewayB, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if found.Token != "t-cli" {
+		t.Errorf("Expected Token to be 't-cli', but got %s", found.Token)
+	}
+
+	// Test case 3: Tokens without an identity are written in the CLI layout
+	legacy := &Token{AccessID: "p-123", Token: "t-legacy", Expiry: time.Now().Add(time.Hour)}
+	if err := WriteTokenFile(legacy, "/path/to/akeyless/.tmp_creds/legacy", config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	data, _ := afero.ReadFile(mockFs, "/path/to/akeyless/.tmp_creds/legacy")
+	if strings.Contains(string(data), "profile_fingerprint") || strings.Contains(string(data), "gateway_url") {
+		t.Errorf("Expected no identity fields in the file, but got %s", data)
+	}
+}
+
+func TestGetTokenWithoutAccessID(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "uid", "[uid]\naccess_type = 'universal_identity'\nuid_token = 'u-123'\n")
+	runner := &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-uid","access_id":"p-from-cli","expiry":1900000000}`}}}
+	config.Runner = runner
+	profile, err := GetProfile("uid", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// The token records the access ID the CLI reported and is still reused by the profile that has none
+	for i := 0; i < 3; i++ {
+		token, err := GetToken(profile, config)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if token.Token != "t-uid" || token.AccessID != "p-from-cli" {
+			t.Errorf("Expected the token t-uid for p-from-cli, but got %+v", token.Expose())
+		}
+	}
+	if calls := len(runner.Calls()); calls != 1 {
+		t.Errorf("Expected the CLI to be invoked once, but got %d calls", calls)
+	}
+
+	// A token cached for another universal identity profile is not reused
+	other := &Profile{Name: "uid", AccessType: "universal_identity", UIDToken: "u-456"}
+	if _, err := CheckForExistingToken(other, config); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+}
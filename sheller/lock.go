@@ -0,0 +1,223 @@
+package sheller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var DEFAULT_LOCK_TIMEOUT = 2 * time.Minute
+
+// DEFAULT_LOCK_STALE_AGE is how long a refresh lock may go without a heartbeat from its holder before it is
+// treated as left behind by a process that was killed while holding it.
+var DEFAULT_LOCK_STALE_AGE = 30 * time.Second
+
+// lockHeartbeatInterval is how often the holder of a refresh lock touches the lock file to show it is still alive.
+var lockHeartbeatInterval = 10 * time.Second
+var lockPollInterval = 100 * time.Millisecond
+
+// refreshLock is an advisory lock file that serialises token refreshes for one identity across processes.
+// While it is held, a heartbeat keeps the modification time of the lock file recent.
+type refreshLock struct {
+	path   string
+	owner  string // Random token written into the lock file, so only the process that created it removes it
+	config *Config
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// RefreshLockPath returns the path of the lock file guarding token refreshes for the profile's access ID,
+// or for its fingerprint when the profile has no access ID, such as a universal identity profile.
+func RefreshLockPath(profile *Profile, config *Config) string {
+	key := profile.AccessID
+	if key == "" {
+		key = profile.Fingerprint()
+	}
+	name := ".sheller-" + sanitizeTokenFileName(key) + ".lock"
+	return filepath.Join(config.AkeylessPath, ".tmp_creds", name)
+}
+
+// acquireRefreshLock creates the refresh lock file for the profile, waiting until it is free.
+// It gives up with ErrRefreshLockTimeout after Config.LockTimeout, and a lock file whose heartbeat stopped
+// more than DEFAULT_LOCK_STALE_AGE ago is treated as left behind by a killed process and recovered.
+func acquireRefreshLock(ctx context.Context, profile *Profile, config *Config) (*refreshLock, error) {
+	path := RefreshLockPath(profile, config)
+	if err := config.AppFs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(config.lockTimeout())
+	waiting := false
+	for {
+		lockFile, err := config.AppFs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			// Only the owner token is used, the other details help diagnose a stuck lock
+			_, err = fmt.Fprintf(lockFile, "owner=%s pid=%d acquired=%s\n", owner, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+			if closeErr := lockFile.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				config.AppFs.Remove(path)
+				return nil, err
+			}
+			lock := &refreshLock{path: path, owner: owner, config: config, stop: make(chan struct{}), done: make(chan struct{})}
+			go lock.heartbeat()
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if recovered, err := recoverStaleLock(path, owner, config); err != nil {
+			return nil, err
+		} else if recovered {
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrRefreshLockTimeout
+		}
+		if !waiting {
+			config.logger().Debug("waiting for another process to refresh the token", "path", path)
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// recoverStaleLock removes the lock file at path if it was abandoned by a process that died while holding it.
+// The lock is first renamed to a name unique to this process, so two processes recovering the same stale lock
+// cannot remove a fresh lock created in between. If the renamed file turns out to be a fresh lock after all, it
+// is put back.
+func recoverStaleLock(path, owner string, config *Config) (bool, error) {
+	info, err := config.AppFs.Stat(path)
+	if err != nil || time.Since(info.ModTime()) <= DEFAULT_LOCK_STALE_AGE {
+		return false, nil
+	}
+	staleOwner := readLockOwner(path, config)
+
+	stalePath := path + "." + owner + ".stale"
+	if err := config.AppFs.Rename(path, stalePath); err != nil {
+		if os.IsNotExist(err) {
+			// Another process recovered the lock first
+			return true, nil
+		}
+		return false, err
+	}
+
+	data, err := config.AppFs.ReadFile(stalePath)
+	if err != nil {
+		return false, err
+	}
+	if lockOwner(data) != staleOwner {
+		// The stale lock was replaced by a fresh one before the rename, so hand it back to its owner
+		config.logger().Debug("a fresh token refresh lock replaced the stale one, restoring it", "path", path)
+		if err := restoreLock(path, stalePath, data, config); err != nil {
+			return false, err
+		}
+		return false, config.AppFs.Remove(stalePath)
+	}
+
+	config.logger().Warn("removed stale token refresh lock", "path", path, "age", time.Since(info.ModTime()))
+	return true, config.AppFs.Remove(stalePath)
+}
+
+// restoreLock recreates a lock file that recoverStaleLock renamed by mistake, keeping its contents and age.
+// If yet another process has created the lock in the meantime, that lock is kept instead.
+func restoreLock(path, stalePath string, data []byte, config *Config) error {
+	info, err := config.AppFs.Stat(stalePath)
+	if err != nil {
+		return err
+	}
+	lockFile, err := config.AppFs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = lockFile.Write(data)
+	if closeErr := lockFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return config.AppFs.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// heartbeat touches the lock file every lockHeartbeatInterval until the lock is released, so other processes
+// can tell a lock held during a long interactive login from one abandoned by a killed process.
+func (l *refreshLock) heartbeat() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if readLockOwner(l.path, l.config) != l.owner {
+				return
+			}
+			now := time.Now()
+			if err := l.config.AppFs.Chtimes(l.path, now, now); err != nil {
+				l.config.logger().Warn("failed to refresh the token refresh lock", "path", l.path, "error", err)
+			}
+		}
+	}
+}
+
+// release stops the heartbeat and removes the lock file so the next waiting process can proceed. A lock file
+// that no longer holds this lock's owner token belongs to another process and is left alone.
+func (l *refreshLock) release() error {
+	close(l.stop)
+	<-l.done
+	if owner := readLockOwner(l.path, l.config); owner != l.owner {
+		l.config.logger().Warn("token refresh lock was taken over by another process", "path", l.path)
+		return nil
+	}
+	return l.config.AppFs.Remove(l.path)
+}
+
+// newLockOwner returns a random token identifying one holder of a refresh lock.
+func newLockOwner() (string, error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(owner), nil
+}
+
+// readLockOwner returns the owner token of the lock file at path, or an empty string when it cannot be read.
+func readLockOwner(path string, config *Config) string {
+	data, err := config.AppFs.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return lockOwner(data)
+}
+
+// lockOwner returns the owner token in the contents of a lock file.
+func lockOwner(data []byte) string {
+	for _, field := range strings.Fields(string(data)) {
+		if owner, ok := strings.CutPrefix(field, "owner="); ok {
+			return owner
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package sheller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestAcquireRefreshLock(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	config.LockTimeout = 250 * time.Millisecond
+	profile := newMockProfile()
+
+	// Test case 1: The lock is free
+	lock, err := acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := mockFs.Stat("/path/to/akeyless/.tmp_creds/.sheller-p-123.lock"); err != nil {
+		t.Errorf("Expected lock file to exist, but got %v", err)
+	}
+
+	// Test case 2: The lock is held and the wait times out
+	if _, err := acquireRefreshLock(context.Background(), profile, config); !errors.Is(err, ErrRefreshLockTimeout) {
+		t.Errorf("Expected ErrRefreshLockTimeout, but got %v", err)
+	}
+
+	// Test case 3: Locks for other access IDs are independent
+	otherLock, err := acquireRefreshLock(context.Background(), &Profile{Name: "other", AccessID: "p-456"}, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	otherLock.release()
+
+	// Test case 4: The lock can be acquired again once released
+	if err := lock.release(); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	lock, err = acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// Test case 5: A lock whose heartbeat stopped is recovered
+	old := time.Now().Add(-DEFAULT_LOCK_STALE_AGE - time.Minute)
+	mockFs.Chtimes(RefreshLockPath(profile, config), old, old)
+	staleLock := lock
+	lock, err = acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected stale lock to be recovered, but got %v", err)
+	}
+
+	// Test case 6: Releasing a lock that was taken over leaves the new owner's lock in place
+	if err := staleLock.release(); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := mockFs.Stat(RefreshLockPath(profile, config)); err != nil {
+		t.Errorf("Expected lock file to exist, but got %v", err)
+	}
+
+	// Test case 7: A cancelled context stops the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := acquireRefreshLock(ctx, profile, config); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+	lock.release()
+}
+
+func TestRefreshLockHeartbeat(t *testing.T) {
+	staleAge, interval := DEFAULT_LOCK_STALE_AGE, lockHeartbeatInterval
+	DEFAULT_LOCK_STALE_AGE, lockHeartbeatInterval = 200*time.Millisecond, 20*time.Millisecond
+	defer func() { DEFAULT_LOCK_STALE_AGE, lockHeartbeatInterval = staleAge, interval }()
+
+	config, mockFs := newMockTokenConfig(t)
+	config.LockTimeout = 400 * time.Millisecond
+	config.AuthTimeout = 0
+	profile := newMockProfile()
+
+	// Test case 1: A lock held for longer than the stale age is kept alive by its heartbeat
+	lock, err := acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := acquireRefreshLock(context.Background(), profile, config); !errors.Is(err, ErrRefreshLockTimeout) {
+		t.Errorf("Expected ErrRefreshLockTimeout, but got %v", err)
+	}
+	lock.release()
+
+	// Test case 2: A lock left by a killed process is recovered even without an auth timeout
+	path := RefreshLockPath(profile, config)
+	afero.WriteFile(mockFs, path, []byte("owner=dead pid=1\n"), 0600)
+	old := time.Now().Add(-time.Minute)
+	mockFs.Chtimes(path, old, old)
+	lock, err = acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected stale lock to be recovered, but got %v", err)
+	}
+	lock.release()
+	if files, _ := afero.ReadDir(mockFs, "/path/to/akeyless/.tmp_creds"); len(files) != 0 {
+		t.Errorf("Expected an empty token cache directory, but got %d files", len(files))
+	}
+}
+
+func TestRefreshLockPathWithoutAccessID(t *testing.T) {
+	config, _ := newMockTokenConfig(t)
+	uidA := &Profile{Name: "uid-a", AccessType: "universal_identity", UIDToken: "u-a"}
+	uidB := &Profile{Name: "uid-b", AccessType: "universal_identity", UIDToken: "u-b"}
+
+	// Universal identity profiles without an access ID do not share a lock
+	if RefreshLockPath(uidA, config) == RefreshLockPath(uidB, config) {
+		t.Errorf("Expected different lock paths, but both are %s", RefreshLockPath(uidA, config))
+	}
+	if strings.Contains(RefreshLockPath(uidA, config), ".sheller-.lock") {
+		t.Errorf("Expected the lock to be keyed on the fingerprint, but got %s", RefreshLockPath(uidA, config))
+	}
+}
+
+func TestGetTokenWaitsForRefreshLock(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	runner := &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	config.Runner = runner
+	profile := newMockProfile()
+
+	// Simulate another process that holds the lock while it authenticates and then caches its token
+	lock, err := acquireRefreshLock(context.Background(), profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	writeMockTokenFile(t, mockFs, ".pending", "p-123", "t-other", time.Now().Add(time.Hour))
+	go func() {
+		time.Sleep(3 * lockPollInterval)
+		mockFs.Rename("/path/to/akeyless/.tmp_creds/.pending", "/path/to/akeyless/.tmp_creds/from-other-process")
+		lock.release()
+	}()
+
+	token, err := GetToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-other" {
+		t.Errorf("Expected Token to be 't-other', but got %s", token.Token)
+	}
+	if calls := len(runner.Calls()); calls != 0 {
+		t.Errorf("Expected no CLI calls, but got %d", calls)
+	}
+}
@@ -0,0 +1,28 @@
+package sheller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// discardHandler is a slog.Handler that drops every record, keeping the library silent by default.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// logger returns the logger sheller writes to. A configured Logger is always used; otherwise Debug enables
+// debug logging to stderr and without either the library produces no output at all. Sensitive attributes
+// are masked before they reach the handler.
+func (config *Config) logger() *slog.Logger {
+	if config.Logger != nil {
+		return slog.New(redactingHandler{config.Logger.Handler()})
+	}
+	if config.Debug {
+		return slog.New(redactingHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})})
+	}
+	return slog.New(discardHandler{})
+}
@@ -0,0 +1,76 @@
+package sheller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestLoggerSilentByDefault(t *testing.T) {
+	// Capture stdout to make sure nothing is printed by the library
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	NewConfigWithDefaults()
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	if _, err := GetToken(newMockProfile(), config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if config.logger().Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("Expected the default logger to be disabled")
+	}
+
+	writer.Close()
+	output, _ := io.ReadAll(reader)
+	if len(output) != 0 {
+		t.Errorf("Expected no output on stdout, but got %q", output)
+	}
+}
+
+func TestLoggerStructuredEvents(t *testing.T) {
+	var buf bytes.Buffer
+	config, mockFs := newMockTokenConfig(t)
+	config.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	profile := newMockProfile()
+
+	// A miss followed by a CLI invocation, then a hit on the cached token
+	GetToken(profile, config)
+	GetToken(profile, config)
+
+	var messages []string
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("Expected JSON log records, but got %q", line)
+		}
+		if record["profile"] != "default" {
+			t.Errorf("Expected every record to carry the profile, but got %v", record)
+		}
+		messages = append(messages, record["msg"].(string))
+	}
+
+	for _, expected := range []string{"token cache miss", "invoking the Akeyless CLI", "obtained a new token", "token cache hit"} {
+		found := false
+		for _, msg := range messages {
+			if msg == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %q log record, but got %q", expected, messages)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package sheller
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenManager keeps the current token for a profile in memory and is safe for concurrent use.
+// Concurrent callers that find the token missing or about to expire share a single refresh,
+// so at most one token lookup or Akeyless CLI invocation is in flight per manager.
+type TokenManager struct {
+	profile *Profile
+	config  *Config
+
+	ctx       context.Context // Lifetime of the manager, cancelled by Close
+	cancel    context.CancelFunc
+	refreshes sync.WaitGroup
+
+	mu       sync.Mutex
+	token    *Token
+	inflight *tokenCall
+}
+
+// tokenCall is a refresh in progress that concurrent callers wait on.
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewTokenManager creates a TokenManager for the profile using the provided configuration.
+// Call Close when the manager is no longer needed to stop any refresh still in flight.
+func NewTokenManager(profile *Profile, config *Config) *TokenManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TokenManager{
+		profile: profile,
+		config:  config,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// InitializeTokenManager initializes the library and creates a TokenManager for the configured profile.
+func InitializeTokenManager(config *Config) (*TokenManager, error) {
+	err := InitializeLibrary(config)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := GetProfile(config.Profile, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewTokenManager(profile, config), nil
+}
+
+// Token returns a valid token for the profile, refreshing it when it is missing or within the expiry buffer.
+// It returns early with the context error if ctx is done before the refresh completes; the refresh itself
+// carries on so the other waiters still receive its result.
+func (m *TokenManager) Token(ctx context.Context) (*Token, error) {
+	m.mu.Lock()
+	if m.token != nil && m.token.Expiry.After(time.Now().Add(m.config.ExpiryBuffer)) {
+		token := *m.token
+		m.mu.Unlock()
+		return &token, nil
+	}
+	call := m.inflight
+	if call == nil {
+		call = m.startRefresh(time.Now().Add(m.config.ExpiryBuffer))
+	}
+	m.mu.Unlock()
+
+	return call.wait(ctx)
+}
+
+// Refresh obtains a newer token than the one currently held, even if the current one is still valid.
+// A token cached by another process is used when it outlives the current one; otherwise the CLI is invoked.
+// If a refresh is already in flight, Refresh waits for it instead of starting another.
+func (m *TokenManager) Refresh(ctx context.Context) (*Token, error) {
+	m.mu.Lock()
+	call := m.inflight
+	if call == nil {
+		validAfter := time.Now().Add(m.config.ExpiryBuffer)
+		if m.token != nil && !m.token.Expiry.Before(validAfter) {
+			validAfter = m.token.Expiry
+		}
+		call = m.startRefresh(validAfter)
+	}
+	m.mu.Unlock()
+
+	return call.wait(ctx)
+}
+
+// current returns the token held in memory, or nil if there is none.
+func (m *TokenManager) current() *Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == nil {
+		return nil
+	}
+	token := *m.token
+	return &token
+}
+
+// Invalidate discards the in-memory token so the next call to Token fetches a new one,
+// for example after the Akeyless API rejected the current token.
+func (m *TokenManager) Invalidate() {
+	m.mu.Lock()
+	m.token = nil
+	m.mu.Unlock()
+}
+
+// Close cancels any refresh in flight, stopping the Akeyless CLI, and waits for it to finish. Once the manager
+// is closed, Token still returns the token held in memory while it is valid, but any call that would need a
+// refresh fails with context.Canceled.
+func (m *TokenManager) Close() error {
+	// Cancelling under m.mu ensures no refresh can be started once Wait begins
+	m.mu.Lock()
+	m.cancel()
+	m.mu.Unlock()
+	m.refreshes.Wait()
+	return nil
+}
+
+// startRefresh starts a refresh for a token valid after validAfter that runs until it completes or the manager is
+// closed. Once the manager is closed it returns a call that has already failed. It must be called with m.mu held.
+func (m *TokenManager) startRefresh(validAfter time.Time) *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	if call.err = m.ctx.Err(); call.err != nil {
+		close(call.done)
+		return call
+	}
+
+	m.inflight = call
+	m.refreshes.Add(1)
+	go func() {
+		defer m.refreshes.Done()
+		m.refresh(call, validAfter)
+	}()
+	return call
+}
+
+// refresh fetches a token and publishes the result to everyone waiting on call.
+// The refresh is shared, so it is not tied to any one caller's context and is bounded by Config.AuthTimeout
+// and the lifetime of the manager instead.
+func (m *TokenManager) refresh(call *tokenCall, validAfter time.Time) {
+	log := m.config.logger().With("profile", m.profile.Name)
+	log.Debug("refreshing token", "valid_after", validAfter)
+
+	call.token, call.err = acquireToken(m.ctx, m.profile, m.config, validAfter)
+	if call.err != nil {
+		log.Warn("token refresh failed", "error", call.err)
+	} else {
+		log.Debug("token refreshed", "expiry", call.token.Expiry)
+	}
+
+	m.mu.Lock()
+	if call.err == nil {
+		m.token = call.token
+	}
+	m.inflight = nil
+	m.mu.Unlock()
+
+	close(call.done)
+}
+
+// wait blocks until the call completes or ctx is done, returning a copy of the token.
+func (call *tokenCall) wait(ctx context.Context) (*Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		token := *call.token
+		return &token, nil
+	}
+}
@@ -0,0 +1,165 @@
+package sheller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenManagerSingleflight(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	runner := &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			time.Sleep(50 * time.Millisecond)
+			return &CommandResult{Stdout: []byte(`{"token":"t-new","expiry":1900000000}`)}, nil
+		},
+	}
+	config.Runner = runner
+	manager := NewTokenManager(newMockProfile(), config)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := manager.Token(context.Background())
+			if err == nil && token.Token != "t-new" {
+				err = errors.New("unexpected token " + token.Token)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if calls := len(runner.Calls()); calls != 1 {
+		t.Errorf("Expected 1 CLI call, but got %d", calls)
+	}
+
+	// The token is now served from memory, even if the cache directory disappears
+	mockFs.RemoveAll("/path/to/akeyless/.tmp_creds")
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+	if calls := len(runner.Calls()); calls != 1 {
+		t.Errorf("Expected 1 CLI call, but got %d", calls)
+	}
+
+	// Invalidate forces the next call to fetch a new token
+	manager.Invalidate()
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+	if calls := len(runner.Calls()); calls != 2 {
+		t.Errorf("Expected 2 CLI calls, but got %d", calls)
+	}
+}
+
+func TestTokenManagerErrorsAndCancellation(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	release := make(chan struct{})
+	runner := &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			<-release
+			return &CommandResult{Stderr: []byte("access denied"), ExitCode: 1}, nil
+		},
+	}
+	config.Runner = runner
+	manager := NewTokenManager(newMockProfile(), config)
+
+	// A caller whose context ends stops waiting while the refresh is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := manager.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, but got %v", err)
+	}
+
+	// A failed refresh is reported and not cached
+	close(release)
+	if _, err := manager.Token(context.Background()); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+	if _, err := manager.Token(context.Background()); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
+
+func TestTokenManagerClose(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	config.AuthTimeout = 0
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+
+	// The CLI waits for input until it is stopped, as a SAML login would
+	started := make(chan struct{})
+	var stopped bool
+	config.Runner = &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			close(started)
+			<-ctx.Done()
+			stopped = true
+			return nil, ctx.Err()
+		},
+	}
+	manager := NewTokenManager(newMockProfile(), config)
+
+	result := make(chan error)
+	go func() {
+		_, err := manager.Token(context.Background())
+		result <- err
+	}()
+	<-started
+
+	// Test case 1: Close stops the CLI and waits for the refresh to finish
+	manager.Close()
+	if !stopped {
+		t.Errorf("Expected the CLI to be stopped before Close returned")
+	}
+	if err := <-result; err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 2: A closed manager no longer refreshes
+	if _, err := manager.Token(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+	if _, err := manager.Refresh(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+}
+
+func TestTokenManagerCloseRacesWithToken(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	config.Runner = &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	// Callers that race with Close either join a cancelled refresh or are refused one
+	for i := 0; i < 20; i++ {
+		manager := NewTokenManager(newMockProfile(), config)
+		var wg sync.WaitGroup
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				manager.Invalidate()
+				manager.Token(context.Background())
+			}()
+		}
+		manager.Close()
+		wg.Wait()
+	}
+}
@@ -1,57 +1,265 @@
 package sheller
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/pelletier/go-toml"
 )
 
 // Profile represents an Akeyless CLI profile.
+// The toml tags are the keys the Akeyless CLI writes to the profile file, and each key is passed to
+// "akeyless auth" as the flag of the same name with underscores converted to hyphens.
 type Profile struct {
-	Name       string `toml:"name"`
+	Name       string `toml:"-"` // Profile name, taken from the file name
 	AccessID   string `toml:"access_id"`
 	AccessType string `toml:"access_type"`
-	// ... other properties as needed
+
+	// access_key
+	AccessKey string `toml:"access_key"`
+
+	// password
+	AdminEmail    string `toml:"admin_email"`
+	AdminPassword string `toml:"admin_password"`
+
+	// cert
+	CertFileName string `toml:"cert_file_name"`
+	CertData     string `toml:"cert_data"`
+	KeyFileName  string `toml:"key_file_name"`
+	KeyData      string `toml:"key_data"`
+
+	// k8s
+	K8sAuthConfigName      string `toml:"k8s_auth_config_name"`
+	K8sServiceAccountToken string `toml:"k8s_service_account_token"`
+	GatewayURL             string `toml:"gateway_url"`
+
+	// azure_ad, gcp and oci
+	AzureADObjectID string `toml:"azure_ad_object_id"`
+	GCPAudience     string `toml:"gcp_audience"`
+	OCIAuthType     string `toml:"oci_auth_type"`
+	OCIGroupOCID    string `toml:"oci_group_ocid"`
+
+	// universal_identity and jwt
+	UIDToken string `toml:"uid_token"`
+	JWT      string `toml:"jwt"`
+
+	// ldap
+	LDAPProxyURL string `toml:"ldap_proxy_url"`
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+
+	// saml and oidc
+	UseRemoteBrowser bool `toml:"use_remote_browser"`
+
+	// Extra holds keys this struct does not model, and modelled keys whose value has an unexpected type,
+	// so that they are still passed to the CLI
+	Extra map[string]interface{} `toml:"-"`
+
+	// setKeys records the keys present in the profile file, so settings explicitly set to an empty value are kept
+	setKeys map[string]bool
+}
+
+// profileFields maps each profile key to the index of the Profile field that holds it.
+var profileFields = func() map[string]int {
+	fields := map[string]int{}
+	profileType := reflect.TypeOf(Profile{})
+	for i := 0; i < profileType.NumField(); i++ {
+		if key := profileType.Field(i).Tag.Get("toml"); key != "" && key != "-" {
+			fields[key] = i
+		}
+	}
+	return fields
+}()
+
+// decodeProfile builds a Profile from a profile settings table.
+func decodeProfile(name string, tree *toml.Tree) *Profile {
+	profile := &Profile{Name: name, setKeys: map[string]bool{}}
+	fieldValues := reflect.ValueOf(profile).Elem()
+
+	for _, key := range tree.Keys() {
+		profile.setKeys[key] = true
+		// GetPath treats the key literally, whereas Get would split a quoted key containing dots
+		value := tree.GetPath([]string{key})
+		if index, ok := profileFields[key]; ok {
+			field := fieldValues.Field(index)
+			if v := reflect.ValueOf(value); v.IsValid() && v.Type().AssignableTo(field.Type()) {
+				field.Set(v)
+				continue
+			}
+		}
+		if profile.Extra == nil {
+			profile.Extra = map[string]interface{}{}
+		}
+		profile.Extra[key] = value
+	}
+
+	return profile
+}
+
+// Settings returns every profile key that is set, mapped to its value, including the Extra keys.
+// A field counts as set when it is non-zero or when it was present in the profile file.
+func (p *Profile) Settings() map[string]interface{} {
+	settings := map[string]interface{}{}
+	fieldValues := reflect.ValueOf(p).Elem()
+	for key, index := range profileFields {
+		if field := fieldValues.Field(index); !field.IsZero() || p.setKeys[key] {
+			settings[key] = field.Interface()
+		}
+	}
+	for key, value := range p.Extra {
+		settings[key] = value
+	}
+	return settings
+}
+
+// String returns a description of the profile with credential fields masked.
+func (p Profile) String() string {
+	return redactStruct(p)
+}
+
+// GoString returns the same masked description as String, so %#v does not reveal credentials either.
+func (p Profile) GoString() string {
+	return redactStruct(p)
 }
 
 // GetProfile loads the specified profile from the .akeyless/profiles directory.
 func GetProfile(name string, config *Config) (*Profile, error) {
-	profilePath := filepath.Join(config.AkeylessPath, "profiles", fmt.Sprintf("%s.toml", name))
+	profilePath := profileFilePath(name, config)
 	profileData, err := config.AppFs.ReadFile(profilePath)
 	if err != nil {
-		return nil, err
+		return nil, newProfileError(name, profilePath, err)
 	}
 
-	profile := &Profile{}
-	err = toml.Unmarshal(profileData, profile)
+	profileConfig, err := toml.LoadBytes(profileData)
 	if err != nil {
-		return nil, err
+		return nil, &ProfileError{Name: name, Path: profilePath, Err: err}
 	}
-	profile.Name = name // Setting the profile name from the file name
 
-	return profile, nil
+	profileConfigTree, err := profileSettingsTable(name, profileConfig)
+	if err != nil {
+		return nil, &ProfileError{Name: name, Path: profilePath, Err: err}
+	}
+
+	// Setting the profile name from the file name
+	return decodeProfile(name, profileConfigTree), nil
 }
 
-// ListProfiles lists all profiles in the .akeyless/profiles directory.
-func ListProfiles(config *Config) ([]Profile, error) {
+// profileSettingsTable finds the table holding the settings in a parsed profile file. The CLI nests the
+// settings under a table named after the profile, e.g. ['default'], while older or hand-written files
+// put them at the top level. The following layouts are understood:
+//   - a table named after the profile, looked up literally so names containing dots work
+//   - a file whose only content is a single table, for a profile file that was renamed
+//   - a flat file with the settings at the top level
+func profileSettingsTable(name string, root *toml.Tree) (*toml.Tree, error) {
+	if table, ok := root.GetPath([]string{name}).(*toml.Tree); ok {
+		return table, nil
+	}
+
+	var tables []string
+	flat := false
+	for _, key := range root.Keys() {
+		if _, ok := root.GetPath([]string{key}).(*toml.Tree); ok {
+			tables = append(tables, key)
+		} else {
+			flat = true
+		}
+	}
+
+	switch {
+	case flat:
+		return root, nil
+	case len(tables) == 1:
+		return root.GetPath(tables).(*toml.Tree), nil
+	case len(tables) > 1:
+		sort.Strings(tables)
+		return nil, fmt.Errorf("the file has no [%s] table and several other tables to choose from: %s", name, strings.Join(tables, ", "))
+	default:
+		// A file without any settings is most likely still being created
+		return nil, errors.New("the file is empty")
+	}
+}
+
+// newProfileError wraps a filesystem error for a profile file in a ProfileError,
+// classifying missing files as ErrProfileNotFound and permission problems as ErrProfileUnreadable.
+func newProfileError(name, path string, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		err = fmt.Errorf("%w: %w", ErrProfileNotFound, err)
+	case os.IsPermission(err):
+		err = fmt.Errorf("%w: %w", ErrProfileUnreadable, err)
+	}
+	return &ProfileError{Name: name, Path: path, Err: err}
+}
+
+// ListProfilesResult holds the profiles that were loaded by ListProfilesTolerant and the files that could not be.
+type ListProfilesResult struct {
+	Profiles []Profile       // Profiles that loaded, sorted by name
+	Errors   []*ProfileError // One error per profile file that could not be loaded, sorted by name
+}
+
+// Err returns the errors of the files that could not be loaded joined together, or nil if every profile loaded.
+func (r *ListProfilesResult) Err() error {
+	errs := make([]error, len(r.Errors))
+	for i, err := range r.Errors {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+// isProfileFileName reports whether a file in the profiles directory holds a profile. Only *.toml files count;
+// hidden files are skipped, which covers editor lock files such as .#default.toml and the temporary files
+// written while a profile is saved, and so do backup files like default.toml~ or default.toml.bak.
+func isProfileFileName(name string) bool {
+	return filepath.Ext(name) == ".toml" && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "#")
+}
+
+// ListProfilesTolerant lists all profiles in the .akeyless/profiles directory, carrying on past profile files
+// that cannot be loaded and reporting them in the result instead.
+// An error is only returned when the profiles directory itself cannot be read.
+func ListProfilesTolerant(config *Config) (*ListProfilesResult, error) {
 	profilesDir := filepath.Join(config.AkeylessPath, "profiles")
-	files, err := config.AppFs.ReadDir(profilesDir) // Updated to use config.AppFs.ReadDir
+	files, err := config.AppFs.ReadDir(profilesDir)
 	if err != nil {
 		return nil, err
 	}
 
-	var profiles []Profile
+	result := &ListProfilesResult{}
 	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".toml" {
-			profileName := file.Name()[0 : len(file.Name())-len(filepath.Ext(file.Name()))]
-			profile, err := GetProfile(profileName, config)
-			if err != nil {
-				return nil, err
+		if file.IsDir() || !isProfileFileName(file.Name()) {
+			config.logger().Debug("skipping file in the profiles directory", "file", file.Name())
+			continue
+		}
+		profileName := strings.TrimSuffix(file.Name(), ".toml")
+		profile, err := GetProfile(profileName, config)
+		if err != nil {
+			var profileErr *ProfileError
+			if !errors.As(err, &profileErr) {
+				profileErr = &ProfileError{Name: profileName, Path: filepath.Join(profilesDir, file.Name()), Err: err}
 			}
-			profiles = append(profiles, *profile)
+			config.logger().Warn("skipping profile that cannot be loaded", "profile", profileName, "error", err)
+			result.Errors = append(result.Errors, profileErr)
+			continue
 		}
+		result.Profiles = append(result.Profiles, *profile)
 	}
 
-	return profiles, nil
+	return result, nil
+}
+
+// ListProfiles lists all profiles in the .akeyless/profiles directory.
+// It fails on the first profile file that cannot be loaded, use ListProfilesTolerant to load the others regardless.
+func ListProfiles(config *Config) ([]Profile, error) {
+	result, err := ListProfilesTolerant(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, result.Errors[0]
+	}
+	return result.Profiles, nil
 }
@@ -0,0 +1,477 @@
+package sheller
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// newMockProfileConfig returns a config backed by an in-memory filesystem with an empty profiles directory.
+func newMockProfileConfig(t *testing.T) (*Config, afero.Fs) {
+	t.Helper()
+	mockFs := afero.NewMemMapFs()
+	config := NewConfig("/path/to/cli", "default", "/path/to/akeyless", 10*time.Minute, false)
+	config.AppFs = &afero.Afero{Fs: mockFs}
+	mockFs.MkdirAll("/path/to/akeyless/profiles", 0700)
+	return config, mockFs
+}
+
+func TestGetProfileSchema(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/k8s.toml", []byte(`['k8s']
+  access_id = 'p-k8s'
+  access_type = 'k8s'
+  k8s_auth_config_name = 'my-k8s-config'
+  gateway_url = 'https://gw.example.com:8000'
+  use_remote_browser = true
+  gcp_audience = ['first', 'second']
+  custom_flag = 'custom value'
+`), 0600)
+
+	profile, err := GetProfile("k8s", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	expected := Profile{
+		Name:              "k8s",
+		AccessID:          "p-k8s",
+		AccessType:        "k8s",
+		K8sAuthConfigName: "my-k8s-config",
+		GatewayURL:        "https://gw.example.com:8000",
+		UseRemoteBrowser:  true,
+	}
+	if profile.Name != expected.Name || profile.AccessID != expected.AccessID || profile.AccessType != expected.AccessType ||
+		profile.K8sAuthConfigName != expected.K8sAuthConfigName || profile.GatewayURL != expected.GatewayURL ||
+		profile.UseRemoteBrowser != expected.UseRemoteBrowser {
+		t.Errorf("Expected profile to be %v, but got %v", expected, profile)
+	}
+
+	// Unknown keys, and known keys with an unexpected type, are kept in Extra
+	if profile.Extra["custom_flag"] != "custom value" {
+		t.Errorf("Expected Extra to hold custom_flag, but got %v", profile.Extra)
+	}
+	if !reflect.DeepEqual(profile.Extra["gcp_audience"], []interface{}{"first", "second"}) {
+		t.Errorf("Expected Extra to hold the gcp_audience array, but got %v", profile.Extra)
+	}
+
+	// Token acquisition is driven from the parsed profile
+	args, err := buildAuthArgs("akeyless", profile)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	expectedArgs := []string{"akeyless", "auth",
+		"--access-id", "p-k8s",
+		"--access-type", "k8s",
+		"--custom-flag", "custom value",
+		"--gateway-url", "https://gw.example.com:8000",
+		"--gcp-audience", "first", "--gcp-audience", "second",
+		"--k8s-auth-config-name", "my-k8s-config",
+		"--use-remote-browser",
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected args to be %q, but got %q", expectedArgs, args)
+	}
+}
+
+func TestProfileSettings(t *testing.T) {
+	profile := &Profile{Name: "certs", AccessID: "p-cert", AccessType: "cert", CertFileName: "/certs/cert.pem", Extra: map[string]interface{}{"debug": true}}
+	expected := map[string]interface{}{
+		"access_id":      "p-cert",
+		"access_type":    "cert",
+		"cert_file_name": "/certs/cert.pem",
+		"debug":          true,
+	}
+	if settings := profile.Settings(); !reflect.DeepEqual(settings, expected) {
+		t.Errorf("Expected settings to be %v, but got %v", expected, settings)
+	}
+}
+
+func TestProfileStringIsRedacted(t *testing.T) {
+	profile := Profile{Name: "default", AccessID: "p-123", AccessKey: "key-secret", Extra: map[string]interface{}{"client_secret": "extra-secret", "region": "eu"}}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		output := fmt.Sprintf(format, profile)
+		if strings.Contains(output, "key-secret") || strings.Contains(output, "extra-secret") {
+			t.Errorf("Expected %s of the profile to hide secrets, but got %s", format, output)
+		}
+		if !strings.Contains(output, "p-123") || !strings.Contains(output, "region:eu") {
+			t.Errorf("Expected %s of the profile to show non-secret settings, but got %s", format, output)
+		}
+	}
+}
+
+// newFixtureProfileConfig returns a config that reads the CLI-generated profiles in testdata/profiles.
+func newFixtureProfileConfig() *Config {
+	config := NewConfig("/path/to/cli", "default", "testdata", 10*time.Minute, false)
+	config.AppFs = &afero.Afero{Fs: afero.NewReadOnlyFs(afero.NewOsFs())}
+	return config
+}
+
+func TestGetProfileFixtures(t *testing.T) {
+	config := newFixtureProfileConfig()
+
+	tests := []struct {
+		name       string
+		accessID   string
+		accessType string
+		check      func(t *testing.T, profile *Profile)
+	}{
+		{
+			name:       "default",
+			accessID:   "p-abcd1234efgh",
+			accessType: "access_key",
+			check: func(t *testing.T, profile *Profile) {
+				if profile.AccessKey != "c2VjcmV0LWFjY2Vzcy1rZXk=" {
+					t.Errorf("Expected AccessKey to be parsed, but got %q", profile.AccessKey)
+				}
+			},
+		},
+		{
+			name:       "saml",
+			accessID:   "p-saml0001abcd",
+			accessType: "saml",
+			check: func(t *testing.T, profile *Profile) {
+				if _, ok := profile.Settings()["use_remote_browser"]; !ok {
+					t.Errorf("Expected use_remote_browser to be kept as a setting")
+				}
+			},
+		},
+		{
+			name:       "k8s",
+			accessID:   "p-k8s00001abcd",
+			accessType: "k8s",
+			check: func(t *testing.T, profile *Profile) {
+				if profile.GatewayURL != "https://gateway.example.com:8000" || profile.K8sAuthConfigName != "cluster-auth-config" {
+					t.Errorf("Expected k8s settings to be parsed, but got %v", profile)
+				}
+			},
+		},
+		{
+			name:       "cert",
+			accessID:   "p-cert0001abcd",
+			accessType: "cert",
+			check: func(t *testing.T, profile *Profile) {
+				if profile.CertFileName != "/home/ci/My Certs/client.crt" || profile.KeyFileName != "/home/ci/My Certs/client.key" {
+					t.Errorf("Expected cert settings to be parsed, but got %v", profile)
+				}
+			},
+		},
+		{
+			name:       "prod.eu",
+			accessID:   "p-prodeu01abcd",
+			accessType: "universal_identity",
+			check: func(t *testing.T, profile *Profile) {
+				if profile.UIDToken != "u-token-value" {
+					t.Errorf("Expected UIDToken to be parsed, but got %q", profile.UIDToken)
+				}
+			},
+		},
+		{
+			name:       "renamed",
+			accessID:   "p-renamed1abcd",
+			accessType: "aws_iam",
+		},
+		{
+			name:       "flat",
+			accessID:   "p-flat0001abcd",
+			accessType: "gcp",
+			check: func(t *testing.T, profile *Profile) {
+				if profile.GCPAudience != "akeyless.io" {
+					t.Errorf("Expected GCPAudience to be parsed, but got %q", profile.GCPAudience)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := GetProfile(tt.name, config)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if profile.Name != tt.name {
+				t.Errorf("Expected Name to be %s, but got %s", tt.name, profile.Name)
+			}
+			if profile.AccessID != tt.accessID {
+				t.Errorf("Expected AccessID to be %s, but got %s", tt.accessID, profile.AccessID)
+			}
+			if profile.AccessType != tt.accessType {
+				t.Errorf("Expected AccessType to be %s, but got %s", tt.accessType, profile.AccessType)
+			}
+			if len(profile.Extra) != 0 {
+				t.Errorf("Expected no Extra settings, but got %v", profile.Extra)
+			}
+			if tt.check != nil {
+				tt.check(t, profile)
+			}
+		})
+	}
+
+	// A file with several tables and none named after the profile is ambiguous
+	if _, err := GetProfile("ambiguous", config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
+
+func TestCheckForExistingTokenWithFixtureProfile(t *testing.T) {
+	profile, err := GetProfile("default", newFixtureProfileConfig())
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// The access ID parsed from the nested table matches the token the CLI cached
+	config, mockFs := newMockTokenConfig(t)
+	writeMockTokenFile(t, mockFs, "cli-written", "p-abcd1234efgh", "t-cached", time.Now().Add(time.Hour))
+	token, err := CheckForExistingToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-cached" {
+		t.Errorf("Expected Token to be 't-cached', but got %s", token.Token)
+	}
+}
+
+func TestCreateProfile(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+
+	// Test case 1: The file is written exactly as the Akeyless CLI writes it
+	profile := &Profile{Name: "default", AccessID: "p-abcd1234efgh", AccessType: "access_key", AccessKey: "c2VjcmV0LWFjY2Vzcy1rZXk="}
+	if err := CreateProfile(profile, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	expected, err := afero.ReadFile(afero.NewOsFs(), "testdata/profiles/default.toml")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	data, _ := afero.ReadFile(mockFs, "/path/to/akeyless/profiles/default.toml")
+	if string(data) != string(expected) {
+		t.Errorf("Expected the profile file to be\n%s\nbut got\n%s", expected, data)
+	}
+	if info, _ := mockFs.Stat("/path/to/akeyless/profiles/default.toml"); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the profile file mode to be 0600, but got %v", info.Mode().Perm())
+	}
+
+	// Test case 2: Creating an existing profile fails
+	if err := CreateProfile(profile, config); !errors.Is(err, ErrProfileExists) {
+		t.Errorf("Expected ErrProfileExists, but got %v", err)
+	}
+
+	// Test case 3: Names with dots, strings that need escaping and Extra values survive a round trip
+	profile = &Profile{
+		Name:     "prod.eu",
+		AccessID: "p-it's",
+		UIDToken: "line1\nline2",
+		Extra:    map[string]interface{}{"retries": 3, "ratio": 1.0, "regions": []string{"eu", "us"}},
+		setKeys:  map[string]bool{"gateway_url": true},
+	}
+	if err := CreateProfile(profile, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	loaded, err := GetProfile("prod.eu", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if loaded.AccessID != profile.AccessID || loaded.UIDToken != profile.UIDToken {
+		t.Errorf("Expected profile to be %v, but got %v", profile, loaded)
+	}
+	expectedExtra := map[string]interface{}{"retries": int64(3), "ratio": 1.0, "regions": []interface{}{"eu", "us"}}
+	if !reflect.DeepEqual(loaded.Extra, expectedExtra) {
+		t.Errorf("Expected Extra to be %v, but got %v", expectedExtra, loaded.Extra)
+	}
+	if value, ok := loaded.Settings()["gateway_url"]; !ok || value != "" {
+		t.Errorf("Expected the empty gateway_url to be kept, but got %v", loaded.Settings())
+	}
+
+	// Test case 4: Names that are not plain file names are rejected
+	if err := CreateProfile(&Profile{Name: "../escape"}, config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 5: Of several concurrent creates of the same profile exactly one succeeds
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- CreateProfile(&Profile{Name: "racy", AccessID: fmt.Sprintf("p-%d", i), AccessKey: "key"}, config)
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	created := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrProfileExists):
+			t.Errorf("Expected ErrProfileExists, but got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one create to succeed, but got %d", created)
+	}
+}
+
+func TestCreateProfileDoesNotReplace(t *testing.T) {
+	// Test case 1: On the local filesystem the complete file is published and an existing file is kept
+	dir := t.TempDir()
+	config := NewConfig("/path/to/cli", "default", dir, 10*time.Minute, false)
+	config.AppFs = &afero.Afero{Fs: afero.NewOsFs()}
+	if err := CreateProfile(&Profile{Name: "local", AccessID: "p-1", AccessKey: "key"}, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if err := CreateProfile(&Profile{Name: "local", AccessID: "p-2", AccessKey: "key"}, config); !errors.Is(err, ErrProfileExists) {
+		t.Errorf("Expected ErrProfileExists, but got %v", err)
+	}
+	if profile, err := GetProfile("local", config); err != nil || profile.AccessID != "p-1" {
+		t.Errorf("Expected the first profile to be kept, but got %v, %v", profile, err)
+	}
+	if files, _ := afero.ReadDir(config.AppFs, filepath.Join(dir, "profiles")); len(files) != 1 {
+		t.Errorf("Expected only the profile file, but got %d files", len(files))
+	}
+
+	// Test case 2: An empty profile file is an error rather than an empty profile
+	mockConfig, mockFs := newMockProfileConfig(t)
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/empty.toml", nil, 0600)
+	if _, err := GetProfile("empty", mockConfig); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 3: A fresh empty file may still be being written, an old one is left over and replaced
+	if err := CreateProfile(&Profile{Name: "empty", AccessID: "p-1", AccessKey: "key"}, mockConfig); !errors.Is(err, ErrProfileExists) {
+		t.Errorf("Expected ErrProfileExists, but got %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	mockFs.Chtimes("/path/to/akeyless/profiles/empty.toml", old, old)
+	if err := CreateProfile(&Profile{Name: "empty", AccessID: "p-1", AccessKey: "key"}, mockConfig); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if profile, err := GetProfile("empty", mockConfig); err != nil || profile.AccessID != "p-1" {
+		t.Errorf("Expected the created profile, but got %v, %v", profile, err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/default.toml", []byte(`['default']
+  access_id = 'p-old'
+  access_key = 'old-key'
+  access_type = 'access_key'
+  custom_flag = 'keep me'
+  obsolete = 'remove me'
+['other']
+  access_id = 'p-other'
+`), 0600)
+
+	// Test case 1: Changed keys are written, unrelated keys and tables are kept and nil keys are removed
+	profile := &Profile{Name: "default", AccessID: "p-new", AccessKey: "new-key", Extra: map[string]interface{}{"obsolete": nil}}
+	if err := UpdateProfile(profile, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	data, _ := afero.ReadFile(mockFs, "/path/to/akeyless/profiles/default.toml")
+	expected := `['default']
+  access_id = 'p-new'
+  access_key = 'new-key'
+  access_type = 'access_key'
+  custom_flag = 'keep me'
+['other']
+  access_id = 'p-other'
+`
+	if string(data) != expected {
+		t.Errorf("Expected the profile file to be\n%s\nbut got\n%s", expected, data)
+	}
+
+	// Test case 2: A flat profile file stays flat
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/flat.toml", []byte("access_id = 'p-flat'\nregion = 'eu'\n"), 0600)
+	if err := UpdateProfile(&Profile{Name: "flat", AccessKey: "flat-key"}, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	data, _ = afero.ReadFile(mockFs, "/path/to/akeyless/profiles/flat.toml")
+	if expected := "access_id = 'p-flat'\naccess_key = 'flat-key'\nregion = 'eu'\n"; string(data) != expected {
+		t.Errorf("Expected the profile file to be\n%s\nbut got\n%s", expected, data)
+	}
+
+	// Test case 3: Updating a missing profile fails
+	if err := UpdateProfile(&Profile{Name: "missing"}, config); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, but got %v", err)
+	}
+}
+
+func TestDeleteProfile(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+	afero.WriteFile(mockFs, "/path/to/akeyless/profiles/default.toml", []byte("['default']\n  access_id = 'p-123'\n"), 0600)
+
+	// Test case 1: The profile file is removed
+	if err := DeleteProfile("default", config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if exists, _ := afero.Exists(mockFs, "/path/to/akeyless/profiles/default.toml"); exists {
+		t.Errorf("Expected the profile file to be removed")
+	}
+
+	// Test case 2: Deleting a missing profile fails
+	if err := DeleteProfile("default", config); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, but got %v", err)
+	}
+}
+
+func TestListProfilesTolerant(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+	profilesDir := "/path/to/akeyless/profiles"
+	afero.WriteFile(mockFs, profilesDir+"/default.toml", []byte("['default']\n  access_id = 'p-default'\n"), 0600)
+	afero.WriteFile(mockFs, profilesDir+"/prod.toml", []byte("['prod']\n  access_id = 'p-prod'\n"), 0600)
+	afero.WriteFile(mockFs, profilesDir+"/broken.toml", []byte("['broken'\n  access_id = "), 0600)
+	// Editor and backup files, hidden files and directories are skipped
+	for _, name := range []string{".#default.toml", "default.toml~", "default.toml.bak", ".default.toml.swp", "#prod.toml#", ".default.toml.1234.tmp", "notes.txt"} {
+		afero.WriteFile(mockFs, profilesDir+"/"+name, []byte("not toml ["), 0600)
+	}
+	mockFs.MkdirAll(profilesDir+"/archive.toml", 0700)
+
+	// Test case 1: The profiles that parse are returned along with an error for the broken file
+	result, err := ListProfilesTolerant(config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	var names []string
+	for _, profile := range result.Profiles {
+		names = append(names, profile.Name)
+	}
+	if expected := []string{"default", "prod"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected profiles to be %v, but got %v", expected, names)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Name != "broken" || result.Errors[0].Path != profilesDir+"/broken.toml" {
+		t.Errorf("Expected a single error for the broken profile, but got %v", result.Errors)
+	}
+	if result.Err() == nil {
+		t.Errorf("Expected Err to report the broken profile, but got nil")
+	}
+
+	// Test case 2: ListProfiles still fails on the broken file
+	if _, err := ListProfiles(config); err == nil || !strings.Contains(err.Error(), "broken.toml") {
+		t.Errorf("Expected an error for broken.toml, but got %v", err)
+	}
+
+	// Test case 3: Without the broken file ListProfiles returns every profile
+	mockFs.Remove(profilesDir + "/broken.toml")
+	profiles, err := ListProfiles(config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(profiles) != 2 {
+		t.Errorf("Expected 2 profiles, but got %d", len(profiles))
+	}
+
+	// Test case 4: A missing profiles directory is an error
+	config.AkeylessPath = "/missing"
+	if _, err := ListProfilesTolerant(config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
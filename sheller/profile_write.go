@@ -0,0 +1,302 @@
+package sheller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// profileFilePath returns the path of the file holding the named profile.
+func profileFilePath(name string, config *Config) string {
+	return filepath.Join(config.AkeylessPath, "profiles", name+".toml")
+}
+
+// checkProfileName rejects profile names that cannot be used as a file name in the profiles directory.
+func checkProfileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%q is not a valid profile name", name)
+	}
+	return nil
+}
+
+// CreateProfile writes a new profile file in the format the Akeyless CLI writes, with the settings nested
+// under a table named after the profile. It fails with ErrProfileExists if the profile file already exists,
+// including when another process creates the same profile concurrently.
+func CreateProfile(profile *Profile, config *Config) error {
+	if err := checkProfileName(profile.Name); err != nil {
+		return &ProfileError{Name: profile.Name, Err: err}
+	}
+	profilePath := profileFilePath(profile.Name, config)
+
+	root, _ := toml.TreeFromMap(map[string]interface{}{})
+	table, _ := toml.TreeFromMap(map[string]interface{}{})
+	root.SetPath([]string{profile.Name}, table)
+	applyProfileSettings(table, profile)
+
+	// The complete file is published without replacing an existing one, so only one of several concurrent creates wins
+	err := writeProfileFile(profile.Name, profilePath, root, config, writeFileExclusive)
+	if errors.Is(err, os.ErrExist) && removeStaleProfileFile(profilePath, config) {
+		err = writeProfileFile(profile.Name, profilePath, root, config, writeFileExclusive)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return &ProfileError{Name: profile.Name, Path: profilePath, Err: ErrProfileExists}
+	}
+	return err
+}
+
+// removeStaleProfileFile removes an empty profile file left behind by a create that was interrupted on a
+// filesystem without hard links, once it is too old to still be being written. It reports whether it did.
+func removeStaleProfileFile(path string, config *Config) bool {
+	info, err := config.AppFs.Stat(path)
+	if err != nil || info.Size() != 0 || time.Since(info.ModTime()) < DEFAULT_LOCK_STALE_AGE {
+		return false
+	}
+	config.logger().Warn("removing empty profile file left behind by an interrupted create", "path", path)
+	return config.AppFs.Remove(path) == nil
+}
+
+// UpdateProfile writes the settings of an existing profile back to its file. Keys in the file that the
+// profile does not set, as well as any other tables in the file, are kept as they are. A key is removed
+// from the file by setting it to nil in profile.Extra.
+func UpdateProfile(profile *Profile, config *Config) error {
+	if err := checkProfileName(profile.Name); err != nil {
+		return &ProfileError{Name: profile.Name, Err: err}
+	}
+	profilePath := profileFilePath(profile.Name, config)
+	profileData, err := config.AppFs.ReadFile(profilePath)
+	if err != nil {
+		return newProfileError(profile.Name, profilePath, err)
+	}
+
+	root, err := toml.LoadBytes(profileData)
+	if err != nil {
+		return &ProfileError{Name: profile.Name, Path: profilePath, Err: err}
+	}
+	table, err := profileSettingsTable(profile.Name, root)
+	if err != nil {
+		return &ProfileError{Name: profile.Name, Path: profilePath, Err: err}
+	}
+	applyProfileSettings(table, profile)
+
+	return writeProfileFile(profile.Name, profilePath, root, config, writeFileAtomic)
+}
+
+// DeleteProfile removes the profile file of the named profile.
+func DeleteProfile(name string, config *Config) error {
+	if err := checkProfileName(name); err != nil {
+		return &ProfileError{Name: name, Err: err}
+	}
+	profilePath := profileFilePath(name, config)
+	if err := config.AppFs.Remove(profilePath); err != nil {
+		return newProfileError(name, profilePath, err)
+	}
+	config.logger().Debug("deleted profile", "profile", name, "path", profilePath)
+	return nil
+}
+
+// applyProfileSettings sets every setting of the profile in a profile settings table, deleting keys set to nil.
+func applyProfileSettings(table *toml.Tree, profile *Profile) {
+	for key, value := range profile.Settings() {
+		if value == nil {
+			table.DeletePath([]string{key})
+			continue
+		}
+		table.SetPath([]string{key}, value)
+	}
+}
+
+// writeProfileFile encodes a profile file and writes it with write, either writeFileAtomic or
+// writeFileExclusive, readable only by its owner.
+func writeProfileFile(name, path string, root *toml.Tree, config *Config, write func(*Config, string, []byte, os.FileMode) error) error {
+	data, err := encodeProfileFile(root)
+	if err != nil {
+		return &ProfileError{Name: name, Path: path, Err: err}
+	}
+	if err := config.AppFs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return &ProfileError{Name: name, Path: path, Err: err}
+	}
+	if err := write(config, path, data, 0600); err != nil {
+		return &ProfileError{Name: name, Path: path, Err: err}
+	}
+	config.logger().Debug("wrote profile", "profile", name, "path", path)
+	return nil
+}
+
+// encodeProfileFile encodes a parsed profile file the way the Akeyless CLI writes it: top-level keys first,
+// then one table per profile with its keys indented by two spaces, keys sorted and strings as literal strings.
+// Tables nested inside a table are written inline.
+func encodeProfileFile(root *toml.Tree) ([]byte, error) {
+	var b strings.Builder
+	var tables []string
+	for _, key := range sortedKeys(root) {
+		value := root.GetPath([]string{key})
+		if _, ok := value.(*toml.Tree); ok {
+			tables = append(tables, key)
+			continue
+		}
+		if err := encodeProfileKey(&b, "", key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range tables {
+		table := root.GetPath([]string{name}).(*toml.Tree)
+		// The CLI always quotes the table name, e.g. ['default']
+		b.WriteString("[" + encodeTOMLString(name) + "]\n")
+		for _, key := range sortedKeys(table) {
+			if err := encodeProfileKey(&b, "  ", key, table.GetPath([]string{key})); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// encodeProfileKey writes a single key = value line.
+func encodeProfileKey(b *strings.Builder, indent, key string, value interface{}) error {
+	encoded, err := encodeTOMLValue(value)
+	if err != nil {
+		return fmt.Errorf("cannot write the key %s: %w", key, err)
+	}
+	b.WriteString(indent + encodeTOMLKey(key) + " = " + encoded + "\n")
+	return nil
+}
+
+// sortedKeys returns the keys of a table in sorted order.
+func sortedKeys(tree *toml.Tree) []string {
+	keys := tree.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeTOMLKey returns key as a bare key when possible and as a quoted key otherwise.
+func encodeTOMLKey(key string) string {
+	bare := key != ""
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			bare = false
+			break
+		}
+	}
+	if bare {
+		return key
+	}
+	return encodeTOMLString(key)
+}
+
+// encodeTOMLString returns s as a literal string, or as a basic string when s contains characters a literal string cannot hold.
+func encodeTOMLString(s string) string {
+	literal := true
+	for _, r := range s {
+		if r == '\'' || r < 0x20 && r != '\t' || r == 0x7f {
+			literal = false
+			break
+		}
+	}
+	if literal {
+		return "'" + s + "'"
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// encodeTOMLValue encodes a profile value: strings, booleans, numbers, times, arrays and tables.
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return encodeTOMLString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case toml.LocalDate, toml.LocalDateTime, toml.LocalTime:
+		return fmt.Sprint(v), nil
+	case *toml.Tree:
+		parts := make([]string, 0, len(v.Keys()))
+		for _, key := range sortedKeys(v) {
+			encoded, err := encodeTOMLValue(v.GetPath([]string{key}))
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, encodeTOMLKey(key)+" = "+encoded)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return encodeTOMLFloat(rv.Float()), nil
+	case reflect.Slice, reflect.Array:
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			encoded, err := encodeTOMLValue(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			parts[i] = encoded
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", value)
+}
+
+// encodeTOMLFloat formats f so it is read back as a float rather than an integer.
+func encodeTOMLFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
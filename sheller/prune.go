@@ -0,0 +1,170 @@
+package sheller
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// DEFAULT_PRUNE_GRACE_PERIOD is how long after expiry a token is kept when Config.AutoPrune prunes the token cache.
+var DEFAULT_PRUNE_GRACE_PERIOD = 24 * time.Hour
+
+// tempFilePattern matches the temporary files writeFileAtomic creates, which are left behind when a process
+// dies mid-write.
+var tempFilePattern = regexp.MustCompile(`^\..+\.[0-9]+\.tmp$`)
+
+// PruneOptions controls PruneTokenCache.
+type PruneOptions struct {
+	GracePeriod time.Duration // How long after its expiry a token is kept, zero removes tokens as soon as they expire
+	DryRun      bool          // Report what would be removed without removing anything
+}
+
+// PruneEntry describes one file PruneTokenCache looked at.
+type PruneEntry struct {
+	Path   string
+	Expiry time.Time // Expiry of the token in the file, zero when the file holds no readable token
+	Reason string    // Why the file was removed, kept or skipped
+}
+
+// PruneReport lists what PruneTokenCache did with each file in the token cache directories.
+type PruneReport struct {
+	Removed []PruneEntry // Files that were removed, or would have been in a dry run
+	Kept    []PruneEntry // Token files that are still valid or within the grace period
+	Skipped []PruneEntry // Files that could not be identified as token files and were left alone
+}
+
+// PruneTokenCache removes expired tokens from the .tmp_creds directory, and from the encrypted token directory
+// when the encrypted token cache is configured. A token is removed once it has been expired for longer than
+// opts.GracePeriod. Temporary files left behind by interrupted writes are removed once they are older than both
+// the grace period and DEFAULT_LOCK_STALE_AGE. Any file that cannot be positively identified as a token file,
+// including lock files and encrypted files that do not decrypt with the configured key, is never removed.
+func PruneTokenCache(config *Config, opts PruneOptions) (*PruneReport, error) {
+	report := &PruneReport{}
+	now := time.Now()
+
+	if err := pruneTokenDir(config, opts, report, now); err != nil {
+		return report, err
+	}
+
+	if config.TokenStore != nil || config.encryptionConfigured() {
+		store, err := config.tokenStore()
+		if err != nil {
+			return report, err
+		}
+		if encrypted, ok := store.(*EncryptedFileTokenStore); ok {
+			if err := pruneEncryptedTokenDir(encrypted, config, opts, report, now); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	config.logger().Debug("pruned the token cache", "removed", len(report.Removed), "kept", len(report.Kept), "skipped", len(report.Skipped), "dry_run", opts.DryRun)
+	return report, nil
+}
+
+// pruneTokenDir prunes the plaintext token files in the .tmp_creds directory.
+func pruneTokenDir(config *Config, opts PruneOptions, report *PruneReport, now time.Time) error {
+	dir := filepath.Join(config.AkeylessPath, ".tmp_creds")
+	files, err := config.AppFs.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		switch {
+		case file.IsDir():
+			continue
+		case tempFilePattern.MatchString(file.Name()):
+			pruneTempFile(config, opts, report, path, file, now)
+			continue
+		case filepath.Ext(file.Name()) != "":
+			report.Skipped = append(report.Skipped, PruneEntry{Path: path, Reason: "not a token file name"})
+			continue
+		}
+
+		token, err := ParseTokenFileWithConfig(path, config)
+		switch {
+		case err != nil:
+			report.Skipped = append(report.Skipped, PruneEntry{Path: path, Reason: "cannot be parsed as a token file: " + err.Error()})
+		case token.Token == "" || token.Expiry.Unix() == 0:
+			report.Skipped = append(report.Skipped, PruneEntry{Path: path, Reason: "does not hold a token with an expiry"})
+		default:
+			pruneToken(config, opts, report, path, token.Expiry, now)
+		}
+	}
+	return nil
+}
+
+// pruneEncryptedTokenDir prunes the token files of an EncryptedFileTokenStore.
+func pruneEncryptedTokenDir(store *EncryptedFileTokenStore, config *Config, opts PruneOptions, report *PruneReport, now time.Time) error {
+	files, err := config.AppFs.ReadDir(store.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(store.dir, file.Name())
+		switch {
+		case file.IsDir() || file.Name() == passphraseSaltName:
+			continue
+		case tempFilePattern.MatchString(file.Name()):
+			pruneTempFile(config, opts, report, path, file, now)
+			continue
+		case filepath.Ext(file.Name()) != encryptedTokenExt:
+			report.Skipped = append(report.Skipped, PruneEntry{Path: path, Reason: "not a token file name"})
+			continue
+		}
+
+		data, err := config.AppFs.ReadFile(path)
+		var token *Token
+		if err == nil {
+			token, err = store.decrypt(data, file.Name()[:len(file.Name())-len(encryptedTokenExt)])
+		}
+		if err != nil {
+			report.Skipped = append(report.Skipped, PruneEntry{Path: path, Reason: "cannot be decrypted: " + err.Error()})
+			continue
+		}
+		pruneToken(config, opts, report, path, token.Expiry, now)
+	}
+	return nil
+}
+
+// pruneToken removes a token file whose token expired more than the grace period ago and keeps it otherwise.
+func pruneToken(config *Config, opts PruneOptions, report *PruneReport, path string, expiry, now time.Time) {
+	if expiry.Add(opts.GracePeriod).After(now) {
+		report.Kept = append(report.Kept, PruneEntry{Path: path, Expiry: expiry, Reason: "still valid or within the grace period"})
+		return
+	}
+	pruneRemove(config, opts, report, PruneEntry{Path: path, Expiry: expiry, Reason: "expired"})
+}
+
+// pruneTempFile removes a leftover temporary file once it is old enough that no write can still be in progress.
+func pruneTempFile(config *Config, opts PruneOptions, report *PruneReport, path string, file os.FileInfo, now time.Time) {
+	if now.Sub(file.ModTime()) < max(opts.GracePeriod, DEFAULT_LOCK_STALE_AGE) {
+		report.Kept = append(report.Kept, PruneEntry{Path: path, Reason: "temporary file that may still be being written"})
+		return
+	}
+	pruneRemove(config, opts, report, PruneEntry{Path: path, Reason: "temporary file left behind by an interrupted write"})
+}
+
+// pruneRemove removes a file, unless this is a dry run, and records it in the report.
+func pruneRemove(config *Config, opts PruneOptions, report *PruneReport, entry PruneEntry) {
+	if !opts.DryRun {
+		if err := config.AppFs.Remove(entry.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			config.logger().Warn("failed to remove token cache file", "path", entry.Path, "error", err)
+			report.Skipped = append(report.Skipped, PruneEntry{Path: entry.Path, Expiry: entry.Expiry, Reason: "cannot be removed: " + err.Error()})
+			return
+		}
+		config.logger().Debug("removed token cache file", "path", entry.Path, "reason", entry.Reason)
+	}
+	report.Removed = append(report.Removed, entry)
+}
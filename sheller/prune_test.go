@@ -0,0 +1,132 @@
+package sheller
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// pruneEntryNames returns the sorted base names of the files in a prune report section.
+func pruneEntryNames(entries []PruneEntry) []string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = filepath.Base(entry.Path)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPruneTokenCache(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	dir := "/path/to/akeyless/.tmp_creds"
+	writeMockTokenFile(t, mockFs, "valid", "p-1", "t-valid", time.Now().Add(time.Hour))
+	writeMockTokenFile(t, mockFs, "recently-expired", "p-2", "t-recent", time.Now().Add(-time.Hour))
+	writeMockTokenFile(t, mockFs, "long-expired", "p-3", "t-old", time.Now().Add(-48*time.Hour))
+	afero.WriteFile(mockFs, dir+"/unrelated", []byte(`{"name":"not a token"}`), 0600)
+	afero.WriteFile(mockFs, dir+"/corrupt", []byte(`{"token":"t-`), 0600)
+	afero.WriteFile(mockFs, dir+"/.sheller-p-1.lock", []byte("pid 1"), 0600)
+	afero.WriteFile(mockFs, dir+"/.valid.12345.tmp", []byte(`{"tok`), 0600)
+	afero.WriteFile(mockFs, dir+"/.fresh.67890.tmp", []byte(`{"tok`), 0600)
+	old := time.Now().Add(-48 * time.Hour)
+	mockFs.Chtimes(dir+"/.valid.12345.tmp", old, old)
+	opts := PruneOptions{GracePeriod: 24 * time.Hour}
+
+	// Test case 1: A dry run reports what would be removed without removing anything
+	opts.DryRun = true
+	report, err := PruneTokenCache(config, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	expectedRemoved := []string{".valid.12345.tmp", "long-expired"}
+	if names := pruneEntryNames(report.Removed); !reflect.DeepEqual(names, expectedRemoved) {
+		t.Errorf("Expected %v to be removed, but got %v", expectedRemoved, names)
+	}
+	if exists, _ := afero.Exists(mockFs, dir+"/long-expired"); !exists {
+		t.Errorf("Expected the dry run to leave long-expired in place")
+	}
+
+	// Test case 2: Expired tokens and stale temporary files are removed, everything else is left alone
+	opts.DryRun = false
+	report, err = PruneTokenCache(config, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if names := pruneEntryNames(report.Removed); !reflect.DeepEqual(names, expectedRemoved) {
+		t.Errorf("Expected %v to be removed, but got %v", expectedRemoved, names)
+	}
+	if names, expected := pruneEntryNames(report.Kept), []string{".fresh.67890.tmp", "recently-expired", "valid"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v to be kept, but got %v", expected, names)
+	}
+	if names, expected := pruneEntryNames(report.Skipped), []string{".sheller-p-1.lock", "corrupt", "unrelated"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v to be skipped, but got %v", expected, names)
+	}
+	files, _ := afero.ReadDir(mockFs, dir)
+	if len(files) != 6 {
+		t.Errorf("Expected 6 files to remain, but got %d", len(files))
+	}
+
+	// Test case 3: Without a grace period a token is removed as soon as it expires
+	report, err = PruneTokenCache(config, PruneOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if names := pruneEntryNames(report.Removed); !reflect.DeepEqual(names, []string{"recently-expired"}) {
+		t.Errorf("Expected recently-expired to be removed, but got %v", names)
+	}
+
+	// Test case 4: A missing cache directory is not an error
+	mockFs.RemoveAll(dir)
+	if _, err := PruneTokenCache(config, opts); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+}
+
+func TestPruneEncryptedTokenCache(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	store, _ := NewEncryptedFileTokenStore("", newMockTokenKey(), config)
+	config.TokenStore = store
+	validProfile := &Profile{Name: "valid", AccessID: "p-1", AccessKey: "key"}
+	expiredProfile := &Profile{Name: "expired", AccessID: "p-2", AccessKey: "key"}
+	store.Put(validProfile, &Token{AccessID: "p-1", Token: "t-valid", Expiry: time.Now().Add(time.Hour)})
+	store.Put(expiredProfile, &Token{AccessID: "p-2", Token: "t-expired", Expiry: time.Now().Add(-time.Hour)})
+	// A file written with another key cannot be identified and must survive
+	otherStore, _ := NewEncryptedFileTokenStore("", make([]byte, EncryptedTokenKeySize), config)
+	otherProfile := &Profile{Name: "other", AccessID: "p-3", AccessKey: "key"}
+	otherStore.Put(otherProfile, &Token{AccessID: "p-3", Token: "t-other", Expiry: time.Now().Add(-time.Hour)})
+
+	report, err := PruneTokenCache(config, PruneOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Path != store.tokenPath(expiredProfile.Fingerprint()) {
+		t.Errorf("Expected only the expired token to be removed, but got %v", report.Removed)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Path != store.tokenPath(otherProfile.Fingerprint()) {
+		t.Errorf("Expected the token encrypted with another key to be skipped, but got %v", report.Skipped)
+	}
+	if exists, _ := afero.Exists(mockFs, store.tokenPath(validProfile.Fingerprint())); !exists {
+		t.Errorf("Expected the valid token to be kept")
+	}
+}
+
+func TestGetTokenAutoPrune(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	writeMockTokenFile(t, mockFs, "long-expired", "p-999", "t-old", time.Now().Add(-2*DEFAULT_PRUNE_GRACE_PERIOD))
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	config.AutoPrune = true
+
+	if _, err := GetToken(newMockProfile(), config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if exists, _ := afero.Exists(mockFs, "/path/to/akeyless/.tmp_creds/long-expired"); exists {
+		t.Errorf("Expected the expired token to be pruned after the refresh")
+	}
+	if exists, _ := afero.Exists(mockFs, TokenFilePath(newMockProfile(), config)); !exists {
+		t.Errorf("Expected the new token to be cached")
+	}
+}
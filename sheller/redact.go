@@ -0,0 +1,218 @@
+package sheller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces secret values in anything sheller logs or returns in errors.
+const redactedValue = "*****"
+
+// sensitiveProfileKeys are the Akeyless profile keys whose values are credentials.
+var sensitiveProfileKeys = map[string]bool{
+	"access_key":                true,
+	"admin_password":            true,
+	"password":                  true,
+	"jwt":                       true,
+	"uid_token":                 true,
+	"cert_data":                 true,
+	"key_data":                  true,
+	"k8s_service_account_token": true,
+	"gcp_jwt":                   true,
+	"token":                     true,
+	"auth_creds":                true,
+	"uam_creds":                 true,
+	"kfm_creds":                 true,
+}
+
+// isSensitiveKey reports whether a profile key, CLI flag or log attribute name holds a secret.
+// Flag names are accepted with or without leading dashes and with hyphens or underscores,
+// and any key mentioning a password or secret is treated as sensitive.
+func isSensitiveKey(key string) bool {
+	key = strings.TrimLeft(key, "-")
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	return sensitiveProfileKeys[key] || strings.Contains(key, "password") || strings.Contains(key, "passphrase") || strings.Contains(key, "secret")
+}
+
+// redactArgs returns a copy of argv with the values of sensitive flags masked.
+// Both "--flag value" and "--flag=value" forms are handled.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if name, _, found := strings.Cut(arg, "="); found {
+			if isSensitiveKey(name) {
+				redacted[i] = name + "=" + redactedValue
+			}
+			continue
+		}
+		if isSensitiveKey(arg) && i+1 < len(redacted) {
+			redacted[i+1] = redactedValue
+			i++
+		}
+	}
+
+	return redacted
+}
+
+// secretArgValues returns the values of the sensitive flags in argv.
+func secretArgValues(args []string) []string {
+	var secrets []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if name, value, found := strings.Cut(arg, "="); found {
+			if isSensitiveKey(name) && value != "" {
+				secrets = append(secrets, value)
+			}
+			continue
+		}
+		if isSensitiveKey(arg) && i+1 < len(args) {
+			if args[i+1] != "" {
+				secrets = append(secrets, args[i+1])
+			}
+			i++
+		}
+	}
+	return secrets
+}
+
+// redactSecrets masks every occurrence of the given secret values in text,
+// for example profile credentials echoed back by the CLI in an error message.
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// redactedError masks secret values in the message of the error it wraps, while errors.Is and errors.As
+// still see the original error.
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+// redactError wraps err so its message has the secret values masked, or returns err when there is nothing to mask.
+func redactError(err error, secrets []string) error {
+	if err == nil || len(secrets) == 0 {
+		return err
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+func (e *redactedError) Error() string {
+	return redactSecrets(e.err.Error(), e.secrets)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactStruct formats a struct as "Type{Field: value, ...}" using its toml or json tags to decide which fields
+// are sensitive. Only fields that are set are shown, sensitive ones masked, and string-keyed maps are masked
+// entry by entry, so the result is safe to log.
+func redactStruct(v interface{}) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var fields []string
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		value := rv.Field(i)
+		if !field.IsExported() || value.IsZero() {
+			continue
+		}
+		key := field.Tag.Get("toml")
+		if key == "" || key == "-" {
+			key = field.Tag.Get("json")
+		}
+		key, _, _ = strings.Cut(key, ",")
+
+		var formatted string
+		switch {
+		case isSensitiveKey(key):
+			formatted = redactedValue
+		case value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String:
+			formatted = redactMap(value)
+		default:
+			formatted = fmt.Sprintf("%v", value.Interface())
+		}
+		fields = append(fields, field.Name+": "+formatted)
+	}
+
+	return rt.Name() + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// redactMap formats a string-keyed map as "map[key:value ...]" in key order with sensitive values masked.
+func redactMap(value reflect.Value) string {
+	keys := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		formatted := redactedValue
+		if !isSensitiveKey(key) {
+			formatted = fmt.Sprintf("%v", value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key())).Interface())
+		}
+		entries[i] = key + ":" + formatted
+	}
+	return "map[" + strings.Join(entries, " ") + "]"
+}
+
+// redactingHandler is a slog.Handler that masks the values of sensitive attributes before passing records on.
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return redactingHandler{h.Handler.WithAttrs(redacted)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr masks a sensitive attribute, descending into groups.
+func redactAttr(attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, redactedValue)
+	}
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	}
+	return attr
+}
@@ -0,0 +1,118 @@
+package sheller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenAndProfileFormattingIsRedacted(t *testing.T) {
+	token := &Token{AccessID: "p-123", Token: "t-secret", Expiry: time.Unix(1900000000, 0), AuthCreds: "auth-secret", UamCreds: "uam-secret", KfmCreds: "kfm-secret"}
+	profile := &Profile{Name: "default", AccessID: "p-123", AccessType: "access_key"}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		for _, value := range []interface{}{token, *token} {
+			output := fmt.Sprintf(format, value)
+			for _, secret := range []string{"t-secret", "auth-secret", "uam-secret", "kfm-secret"} {
+				if strings.Contains(output, secret) {
+					t.Errorf("Expected %s of the token to hide %s, but got %s", format, secret, output)
+				}
+			}
+			if !strings.Contains(output, "p-123") {
+				t.Errorf("Expected %s of the token to show the access ID, but got %s", format, output)
+			}
+		}
+
+		output := fmt.Sprintf(format, profile)
+		if !strings.Contains(output, "access_key") || !strings.Contains(output, "p-123") {
+			t.Errorf("Expected %s of the profile to show non-secret fields, but got %s", format, output)
+		}
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	config := NewConfig("", "default", "", 0, false)
+	config.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	config.logger().With("access_key", "with-secret").Info("event",
+		"access_id", "p-123",
+		"password", "pw-secret",
+		slog.Group("profile", "jwt", "jwt-secret", "gateway_url", "https://gw"),
+	)
+
+	output := buf.String()
+	for _, secret := range []string{"with-secret", "pw-secret", "jwt-secret"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Expected %s to be redacted, but got %s", secret, output)
+		}
+	}
+	for _, visible := range []string{"p-123", "https://gw", "access_key=*****"} {
+		if !strings.Contains(output, visible) {
+			t.Errorf("Expected %s in the log output, but got %s", visible, output)
+		}
+	}
+}
+
+func TestAuthErrorRedactsEchoedSecrets(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\naccess_key = 'super-secret'\n")
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Stderr: `{"error":"invalid access key super-secret"}`, ExitCode: 1}}}
+
+	profile, err := GetProfile("default", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	_, err = ShellOutForNewToken(profile, config)
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected an AuthError, but got %#v", err)
+	}
+	if strings.Contains(err.Error(), "super-secret") || strings.Contains(authErr.Stderr, "super-secret") || strings.Contains(authErr.Message, "super-secret") {
+		t.Errorf("Expected the access key to be redacted, but got %q / %q", err.Error(), authErr.Stderr)
+	}
+	if authErr.Message != "invalid access key *****" {
+		t.Errorf("Expected the redacted message, but got %q", authErr.Message)
+	}
+}
+
+func TestRunnerErrorsAreRedacted(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\naccess_key = 'super-secret'\n")
+	profile, err := GetProfile("default", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// Test case 1: A runner error that echoes the command line does not reveal the access key
+	cause := errors.New("sandbox refused to run /path/to/cli auth --access-key super-secret")
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Err: cause}}}
+	_, err = ShellOutForNewToken(profile, config)
+	if !errors.Is(err, ErrAuthFailed) || !errors.Is(err, cause) {
+		t.Errorf("Expected ErrAuthFailed wrapping the cause, but got %v", err)
+	}
+	if err == nil || strings.Contains(err.Error(), "super-secret") {
+		t.Errorf("Expected the access key to be redacted, but got %v", err)
+	}
+
+	// Test case 2: The same holds for the error of a runner with nothing scripted and for a missing CLI
+	config.Runner = &FakeCommandRunner{}
+	if _, err := ShellOutForNewToken(profile, config); err == nil || strings.Contains(err.Error(), "super-secret") {
+		t.Errorf("Expected the access key to be redacted, but got %v", err)
+	}
+	config.Runner = &FakeCommandRunner{Results: []FakeResult{{Err: fmt.Errorf("exec %q: %w", "--access-key=super-secret", exec.ErrNotFound)}}}
+	if _, err := ShellOutForNewToken(profile, config); !errors.Is(err, ErrCLINotFound) || strings.Contains(err.Error(), "super-secret") {
+		t.Errorf("Expected a redacted ErrCLINotFound, but got %v", err)
+	}
+
+	// Test case 3: Command.String masks secret flag values
+	cmd := Command{Args: []string{"/path/to/cli", "auth", "--access-id", "p-123", "--access-key", "super-secret"}}
+	if got := cmd.String(); strings.Contains(got, "super-secret") || !strings.Contains(got, "p-123") {
+		t.Errorf("Expected only the access key to be masked, but got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package sheller
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+var DEFAULT_REFRESH_MIN_BACKOFF = 1 * time.Second
+var DEFAULT_REFRESH_MAX_BACKOFF = 1 * time.Minute
+
+// RefresherOptions controls the background refresher started with TokenManager.RunRefresher.
+type RefresherOptions struct {
+	Jitter     time.Duration      // Maximum random amount a refresh is brought forward by, defaults to a tenth of the expiry buffer
+	MinBackoff time.Duration      // Delay before the first retry after a failed refresh, doubled on every further failure
+	MaxBackoff time.Duration      // Upper bound on the retry delay
+	OnRefresh  func(RefreshEvent) // Called after every refresh attempt from the refresher goroutine, must not block
+}
+
+// RefreshEvent reports the outcome of a background refresh attempt.
+type RefreshEvent struct {
+	Token    *Token    // The new token, nil when the refresh failed
+	Err      error     // The refresh error, nil when the refresh succeeded
+	Failures int       // Number of consecutive failed attempts, zero after a success
+	Next     time.Time // When the next refresh attempt is scheduled
+}
+
+// RunRefresher renews the token in the background so callers of Token never wait for the Akeyless CLI.
+// The token is refreshed ExpiryBuffer before it expires, brought forward by a random jitter so that many
+// processes sharing a profile do not refresh at the same moment. Failed refreshes are retried with
+// exponential backoff. RunRefresher blocks until ctx is cancelled or the manager is closed and then returns the
+// context error. A refresh in flight is shared with callers of Token, so it is left to finish; Close stops it.
+func (m *TokenManager) RunRefresher(ctx context.Context, opts RefresherOptions) error {
+	opts = m.refresherDefaults(opts)
+
+	failures := 0
+	next := m.nextRefresh(opts, failures)
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-m.ctx.Done():
+			timer.Stop()
+			return m.ctx.Err()
+		case <-timer.C:
+		}
+
+		token, err := m.Refresh(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if m.ctx.Err() != nil {
+			return m.ctx.Err()
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		next = m.nextRefresh(opts, failures)
+		if opts.OnRefresh != nil {
+			opts.OnRefresh(RefreshEvent{
+				Token:    token,
+				Err:      err,
+				Failures: failures,
+				Next:     next,
+			})
+		}
+	}
+}
+
+// refresherDefaults fills in unset refresher options.
+func (m *TokenManager) refresherDefaults(opts RefresherOptions) RefresherOptions {
+	if opts.Jitter <= 0 {
+		opts.Jitter = m.config.ExpiryBuffer / 10
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DEFAULT_REFRESH_MIN_BACKOFF
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DEFAULT_REFRESH_MAX_BACKOFF
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	return opts
+}
+
+// nextRefresh returns when the refresher should next renew the token.
+func (m *TokenManager) nextRefresh(opts RefresherOptions, failures int) time.Time {
+	now := time.Now()
+	if failures > 0 {
+		backoff := opts.MinBackoff
+		for i := 1; i < failures && backoff < opts.MaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+		return now.Add(backoff)
+	}
+
+	token := m.current()
+	if token == nil {
+		return now
+	}
+
+	next := token.Expiry.Add(-m.config.ExpiryBuffer)
+	if opts.Jitter > 0 {
+		next = next.Add(-time.Duration(rand.Int63n(int64(opts.Jitter))))
+	}
+
+	// Never spin on the CLI when the token lifetime is shorter than the expiry buffer
+	if earliest := now.Add(opts.MinBackoff); next.Before(earliest) {
+		next = earliest
+	}
+	return next
+}
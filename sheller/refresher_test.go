@@ -0,0 +1,166 @@
+package sheller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunRefresher(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	config.ExpiryBuffer = time.Second
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+
+	// The first two attempts fail, every later attempt returns a new token that expires in three seconds
+	var mu sync.Mutex
+	attempts := 0
+	config.Runner = &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts <= 2 {
+				return &CommandResult{Stderr: []byte("gateway unreachable"), ExitCode: 1}, nil
+			}
+			output := fmt.Sprintf(`{"token":"t-%d","expiry":%d}`, attempts, time.Now().Add(3*time.Second).Unix())
+			return &CommandResult{Stdout: []byte(output)}, nil
+		},
+	}
+	manager := NewTokenManager(newMockProfile(), config)
+
+	events := make(chan RefreshEvent, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- manager.RunRefresher(ctx, RefresherOptions{
+			Jitter:     10 * time.Millisecond,
+			MinBackoff: 10 * time.Millisecond,
+			MaxBackoff: 50 * time.Millisecond,
+			OnRefresh:  func(event RefreshEvent) { events <- event },
+		})
+	}()
+
+	var received []RefreshEvent
+	for len(received) < 4 {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for refresh events, got %d", len(received))
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+
+	// Failures are counted and retried with backoff
+	if received[0].Err == nil || received[0].Failures != 1 {
+		t.Errorf("Expected the first event to be failure 1, but got %+v", received[0])
+	}
+	if received[1].Err == nil || received[1].Failures != 2 {
+		t.Errorf("Expected the second event to be failure 2, but got %+v", received[1])
+	}
+
+	// Success resets the failure count and the token is renewed ahead of its expiry
+	if received[2].Err != nil || received[2].Failures != 0 || received[2].Token.Token != "t-3" {
+		t.Errorf("Expected the third event to deliver t-3, but got %+v", received[2])
+	}
+	if received[3].Err != nil || received[3].Token.Token != "t-4" {
+		t.Errorf("Expected the fourth event to deliver t-4, but got %+v", received[3])
+	}
+	if !received[3].Token.Expiry.After(received[2].Token.Expiry) {
+		t.Errorf("Expected the renewed token to outlive the previous one")
+	}
+
+	// Callers are served the refreshed token from memory
+	token, err := manager.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token == "" {
+		t.Errorf("Expected a token, but got none")
+	}
+}
+
+func TestNextRefreshBackoff(t *testing.T) {
+	config := NewConfig("", "default", "", 10*time.Minute, false)
+	manager := NewTokenManager(&Profile{Name: "default"}, config)
+	opts := manager.refresherDefaults(RefresherOptions{MinBackoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		delay := time.Until(manager.nextRefresh(opts, tt.failures))
+		if delay > tt.expected || delay < tt.expected-100*time.Millisecond {
+			t.Errorf("Expected backoff after %d failures to be %s, but got %s", tt.failures, tt.expected, delay)
+		}
+	}
+
+	// Without a token the refresher fetches one immediately
+	if delay := time.Until(manager.nextRefresh(opts, 0)); delay > 0 {
+		t.Errorf("Expected an immediate refresh, but got %s", delay)
+	}
+
+	// With a token the refresh happens ExpiryBuffer before expiry, brought forward by at most the jitter
+	manager.token = &Token{Expiry: time.Now().Add(time.Hour)}
+	delay := time.Until(manager.nextRefresh(opts, 0))
+	if delay > 50*time.Minute || delay < 50*time.Minute-opts.Jitter-time.Second {
+		t.Errorf("Expected refresh in about 50m, but got %s", delay)
+	}
+}
+
+func TestRunRefresherLeavesSharedRefresh(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	config.Runner = &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			close(started)
+			select {
+			case <-finish:
+				return &CommandResult{Stdout: []byte(`{"token":"t-new","expiry":1900000000}`)}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	}
+	manager := NewTokenManager(newMockProfile(), config)
+	defer manager.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- manager.RunRefresher(ctx, RefresherOptions{})
+	}()
+	<-started
+
+	// A caller joins the refresh the refresher started
+	result := make(chan error)
+	go func() {
+		_, err := manager.Token(context.Background())
+		result <- err
+	}()
+
+	// Stopping the refresher does not cancel the refresh the caller is waiting for
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+	close(finish)
+	if err := <-result; err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package sheller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Command describes a single process invocation requested by the token manager.
+type Command struct {
+	Args []string // Full argv, Args[0] is the executable
+	Env  []string // Environment in "KEY=value" form, nil inherits the current process environment
+	Dir  string   // Working directory, empty uses the current directory
+}
+
+// String returns the command line joined by spaces, with the values of secret flags masked.
+func (c Command) String() string {
+	return strings.Join(redactArgs(c.Args), " ")
+}
+
+// CommandResult holds the outcome of a completed process.
+type CommandResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// CommandRunner runs commands on behalf of the token manager.
+// Implementations return an error only when the command could not be run at all;
+// a command that ran and exited non-zero is reported through CommandResult.ExitCode.
+type CommandRunner interface {
+	Run(ctx context.Context, cmd Command) (*CommandResult, error)
+}
+
+// ExecCommandRunner runs commands as local processes using os/exec.
+type ExecCommandRunner struct{}
+
+// Run starts the command, waits for it to finish and collects its output.
+func (ExecCommandRunner) Run(ctx context.Context, cmd Command) (*CommandResult, error) {
+	if len(cmd.Args) == 0 {
+		return nil, errors.New("no command to run")
+	}
+
+	execCmd := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...)
+	execCmd.Env = cmd.Env
+	execCmd.Dir = cmd.Dir
+
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+
+	err := execCmd.Run()
+	result := &CommandResult{
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+			return result, nil
+		}
+		return result, err
+	}
+
+	return result, nil
+}
+
+// FakeCommandRunner is a scriptable CommandRunner for tests.
+// Each call to Run consumes the next entry in Results, or calls Handler when Results is exhausted.
+// Every command received is recorded and can be inspected with Calls.
+type FakeCommandRunner struct {
+	Results []FakeResult
+	Handler func(ctx context.Context, cmd Command) (*CommandResult, error)
+
+	mu    sync.Mutex
+	calls []Command
+}
+
+// FakeResult is a canned response returned by FakeCommandRunner.
+type FakeResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error
+}
+
+// Run records the command and returns the next scripted result.
+func (f *FakeCommandRunner) Run(ctx context.Context, cmd Command) (*CommandResult, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, cmd)
+	var next *FakeResult
+	if len(f.Results) > 0 {
+		next = &f.Results[0]
+		f.Results = f.Results[1:]
+	}
+	handler := f.Handler
+	f.mu.Unlock()
+
+	if next != nil {
+		if next.Err != nil {
+			return nil, next.Err
+		}
+		return &CommandResult{
+			Stdout:   []byte(next.Stdout),
+			Stderr:   []byte(next.Stderr),
+			ExitCode: next.ExitCode,
+		}, nil
+	}
+	if handler != nil {
+		return handler(ctx, cmd)
+	}
+	return nil, fmt.Errorf("fake command runner has no result scripted for %q", cmd.String())
+}
+
+// Calls returns a copy of every command the fake runner has received.
+func (f *FakeCommandRunner) Calls() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([]Command, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
@@ -0,0 +1,83 @@
+package sheller
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestExecCommandRunner(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// Test case 1: Stdout, stderr and exit code are captured
+	result, err := ExecCommandRunner{}.Run(context.Background(), Command{
+		Args: []string{sh, "-c", "echo out; echo err >&2; exit 3"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if string(result.Stdout) != "out\n" {
+		t.Errorf("Expected Stdout to be 'out\\n', but got %q", result.Stdout)
+	}
+	if string(result.Stderr) != "err\n" {
+		t.Errorf("Expected Stderr to be 'err\\n', but got %q", result.Stderr)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected ExitCode to be 3, but got %d", result.ExitCode)
+	}
+
+	// Test case 2: Env and Dir are passed to the process
+	result, err = ExecCommandRunner{}.Run(context.Background(), Command{
+		Args: []string{sh, "-c", "echo $SHELLER_TEST; pwd"},
+		Env:  []string{"SHELLER_TEST=value"},
+		Dir:  "/",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if string(result.Stdout) != "value\n/\n" {
+		t.Errorf("Expected Stdout to be 'value\\n/\\n', but got %q", result.Stdout)
+	}
+
+	// Test case 3: A missing executable is reported as an error
+	if _, err := (ExecCommandRunner{}).Run(context.Background(), Command{Args: []string{"/nonexistent/akeyless"}}); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 4: A cancelled context is reported as the context error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (ExecCommandRunner{}).Run(ctx, Command{Args: []string{sh, "-c", "sleep 5"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got %v", err)
+	}
+}
+
+func TestFakeCommandRunner(t *testing.T) {
+	runner := &FakeCommandRunner{
+		Results: []FakeResult{{Stdout: "first"}},
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			return &CommandResult{Stdout: []byte("handled " + cmd.Args[0])}, nil
+		},
+	}
+
+	result, err := runner.Run(context.Background(), Command{Args: []string{"one"}})
+	if err != nil || string(result.Stdout) != "first" {
+		t.Errorf("Expected scripted result 'first', but got %q, %v", result.Stdout, err)
+	}
+	result, err = runner.Run(context.Background(), Command{Args: []string{"two"}})
+	if err != nil || string(result.Stdout) != "handled two" {
+		t.Errorf("Expected handler result 'handled two', but got %q, %v", result.Stdout, err)
+	}
+	if calls := runner.Calls(); len(calls) != 2 || calls[1].Args[0] != "two" {
+		t.Errorf("Expected 2 recorded calls, but got %v", calls)
+	}
+
+	// Without results or a handler the fake runner reports an error
+	if _, err := (&FakeCommandRunner{}).Run(context.Background(), Command{Args: []string{"x"}}); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
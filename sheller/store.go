@@ -0,0 +1,168 @@
+package sheller
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TokenStore caches tokens between GetToken calls, keyed by the identity of the profile they were issued for
+// (see Profile.Fingerprint). Set Config.TokenStore to choose a store; the default is a DirTokenStore.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Get returns the stored token for the profile that expires last, whether or not it is still valid,
+	// or an error matching ErrNoCachedToken when the store holds no token for the profile.
+	Get(profile *Profile) (*Token, error)
+	// Put stores the token as the token of the profile.
+	Put(profile *Profile, token *Token) error
+	// Delete removes every stored token for the profile. Deleting a profile without a token is not an error.
+	Delete(profile *Profile) error
+	// List returns every token in the store.
+	List() ([]*Token, error)
+}
+
+// tokenStore returns the configured TokenStore. Without one it falls back to the EncryptedFileTokenStore described
+// by TokenKeyFile or TokenPassphrase, or else to a DirTokenStore.
+func (config *Config) tokenStore() (TokenStore, error) {
+	switch {
+	case config.TokenStore != nil:
+		return config.TokenStore, nil
+	case config.encryptionConfigured():
+		store, err := config.encryptedTokenStore()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return NewDirTokenStore(config), nil
+	}
+}
+
+// DirTokenStore stores tokens as JSON files in the .tmp_creds directory, where the Akeyless CLI caches its
+// own tokens, so the CLI and sheller share tokens in both directions.
+type DirTokenStore struct {
+	config *Config
+}
+
+// NewDirTokenStore creates a DirTokenStore for the .tmp_creds directory inside config.AkeylessPath.
+func NewDirTokenStore(config *Config) *DirTokenStore {
+	return &DirTokenStore{config: config}
+}
+
+// Get returns the token that expires last among the files in the directory that match the profile.
+// Files that cannot be parsed are skipped, see ScanTokenCache.
+func (s *DirTokenStore) Get(profile *Profile) (*Token, error) {
+	candidates, err := scanTokenCache(profile, s.config, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	var best *Token
+	for _, candidate := range candidates {
+		if candidate.Usable() && (best == nil || candidate.Token.Expiry.After(best.Expiry)) {
+			best = candidate.Token
+		}
+	}
+	s.config.logger().Debug("scanned the token cache", "profile", profile.Name, "files", len(candidates), "found", best != nil)
+	if best == nil {
+		return nil, ErrNoCachedToken
+	}
+	return best, nil
+}
+
+// Put writes the token to the file returned by TokenFilePath.
+func (s *DirTokenStore) Put(profile *Profile, token *Token) error {
+	return SaveToken(profile, token, s.config)
+}
+
+// Delete removes every token file that matches the profile, including files written by the Akeyless CLI.
+func (s *DirTokenStore) Delete(profile *Profile) error {
+	candidates, err := scanTokenCache(profile, s.config, time.Time{})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, candidate := range candidates {
+		if candidate.Err != nil || !candidate.Matches {
+			continue
+		}
+		if err := s.config.AppFs.Remove(candidate.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.config.logger().Debug("deleted cached token", "profile", profile.Name, "path", candidate.Path)
+	}
+	return nil
+}
+
+// List returns the tokens of every file in the directory that can be parsed.
+func (s *DirTokenStore) List() ([]*Token, error) {
+	candidates, err := scanTokenCache(&Profile{}, s.config, time.Time{})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var tokens []*Token
+	for _, candidate := range candidates {
+		if candidate.Err == nil {
+			tokens = append(tokens, candidate.Token)
+		}
+	}
+	return tokens, nil
+}
+
+// MemoryTokenStore keeps tokens in memory only, for tests and short-lived processes that should leave nothing on disk.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// NewMemoryTokenStore creates an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]Token{}}
+}
+
+// Get returns a copy of the token stored for the profile.
+func (s *MemoryTokenStore) Get(profile *Profile) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[profile.Fingerprint()]
+	if !ok {
+		return nil, ErrNoCachedToken
+	}
+	return &token, nil
+}
+
+// Put stores a copy of the token for the profile, replacing any previous token.
+func (s *MemoryTokenStore) Put(profile *Profile, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[profile.Fingerprint()] = *token
+	return nil
+}
+
+// Delete removes the token stored for the profile.
+func (s *MemoryTokenStore) Delete(profile *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, profile.Fingerprint())
+	return nil
+}
+
+// List returns copies of every stored token, sorted by expiry.
+func (s *MemoryTokenStore) List() ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		token := token
+		tokens = append(tokens, &token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Expiry.Before(tokens[j].Expiry) })
+	return tokens, nil
+}
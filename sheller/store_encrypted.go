@@ -0,0 +1,164 @@
+package sheller
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EncryptedTokenKeySize is the size in bytes of the AES-256 key used by EncryptedFileTokenStore.
+const EncryptedTokenKeySize = 32
+
+// encryptedTokenDirName is the directory inside the .akeyless directory that EncryptedFileTokenStore uses by default.
+// It is kept apart from .tmp_creds so the Akeyless CLI never tries to read the encrypted files.
+const encryptedTokenDirName = ".sheller_creds"
+
+// encryptedTokenMagic starts every encrypted token file, identifying the format and its version.
+var encryptedTokenMagic = []byte("SHELLER1")
+
+// encryptedTokenExt is the extension of encrypted token files.
+const encryptedTokenExt = ".token"
+
+// EncryptedFileTokenStore stores each token in its own file encrypted with AES-256-GCM, so bearer tokens are
+// never written to disk in plaintext. Files are named after the fingerprint of their profile, which is also
+// authenticated with the token so a file copied over another profile's file fails to decrypt.
+type EncryptedFileTokenStore struct {
+	dir    string
+	aead   cipher.AEAD
+	config *Config
+}
+
+// NewEncryptedFileTokenStore creates an EncryptedFileTokenStore that keeps its files in dir, or in the
+// .sheller_creds directory inside config.AkeylessPath when dir is empty. key must be EncryptedTokenKeySize bytes.
+func NewEncryptedFileTokenStore(dir string, key []byte, config *Config) (*EncryptedFileTokenStore, error) {
+	if len(key) != EncryptedTokenKeySize {
+		return nil, fmt.Errorf("the token encryption key must be %d bytes, but got %d", EncryptedTokenKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		dir = filepath.Join(config.AkeylessPath, encryptedTokenDirName)
+	}
+	return &EncryptedFileTokenStore{dir: dir, aead: aead, config: config}, nil
+}
+
+// tokenPath returns the path of the file holding the token for the profile with the given fingerprint.
+func (s *EncryptedFileTokenStore) tokenPath(fingerprint string) string {
+	return filepath.Join(s.dir, fingerprint+encryptedTokenExt)
+}
+
+// Get decrypts the token stored for the profile. A file that cannot be decrypted, for example because the key
+// changed, is logged and treated as a cache miss so the token is simply fetched and stored again.
+func (s *EncryptedFileTokenStore) Get(profile *Profile) (*Token, error) {
+	fingerprint := profile.Fingerprint()
+	path := s.tokenPath(fingerprint)
+	data, err := s.config.AppFs.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", ErrNoCachedToken, err)
+		}
+		return nil, err
+	}
+	token, err := s.decrypt(data, fingerprint)
+	if err != nil {
+		s.config.logger().Warn("ignoring encrypted token file that cannot be decrypted", "path", path, "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrNoCachedToken, err)
+	}
+	return token, nil
+}
+
+// Put encrypts the token and writes it atomically to the profile's file with 0600 permissions.
+func (s *EncryptedFileTokenStore) Put(profile *Profile, token *Token) error {
+	return s.put(profile.Fingerprint(), token)
+}
+
+// put encrypts the token and writes it to the file of the profile with the given fingerprint.
+func (s *EncryptedFileTokenStore) put(fingerprint string, token *Token) error {
+	data, err := s.encrypt(token, fingerprint)
+	if err != nil {
+		return err
+	}
+	if err := s.config.AppFs.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.config, s.tokenPath(fingerprint), data, 0600)
+}
+
+// Delete removes the profile's file.
+func (s *EncryptedFileTokenStore) Delete(profile *Profile) error {
+	if err := s.config.AppFs.Remove(s.tokenPath(profile.Fingerprint())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List decrypts every token file in the directory, skipping and logging files that cannot be decrypted.
+func (s *EncryptedFileTokenStore) List() ([]*Token, error) {
+	files, err := s.config.AppFs.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var tokens []*Token
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != encryptedTokenExt || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		data, err := s.config.AppFs.ReadFile(path)
+		if err == nil {
+			var token *Token
+			if token, err = s.decrypt(data, strings.TrimSuffix(file.Name(), encryptedTokenExt)); err == nil {
+				tokens = append(tokens, token)
+				continue
+			}
+		}
+		s.config.logger().Warn("skipping unreadable encrypted token file", "path", path, "error", err)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Expiry.Before(tokens[j].Expiry) })
+	return tokens, nil
+}
+
+// encrypt seals the token file JSON of the token, authenticating the profile fingerprint along with it.
+func (s *EncryptedFileTokenStore) encrypt(token *Token, fingerprint string) ([]byte, error) {
+	plaintext, err := encodeTokenFile(token)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data := append(append([]byte{}, encryptedTokenMagic...), nonce...)
+	return s.aead.Seal(data, nonce, plaintext, []byte(fingerprint)), nil
+}
+
+// decrypt opens an encrypted token file written for the profile with the given fingerprint.
+func (s *EncryptedFileTokenStore) decrypt(data []byte, fingerprint string) (*Token, error) {
+	if !bytes.HasPrefix(data, encryptedTokenMagic) || len(data) < len(encryptedTokenMagic)+s.aead.NonceSize() {
+		return nil, errors.New("the file is not an encrypted token file")
+	}
+	data = data[len(encryptedTokenMagic):]
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(fingerprint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the token file: %w", err)
+	}
+	return decodeTokenFile(plaintext)
+}
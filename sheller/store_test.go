@@ -0,0 +1,163 @@
+package sheller
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// newMockTokenKey returns a fixed AES-256 key for the encrypted token store.
+func newMockTokenKey() []byte {
+	return bytes.Repeat([]byte{0x42}, EncryptedTokenKeySize)
+}
+
+func TestTokenStores(t *testing.T) {
+	stores := map[string]func(t *testing.T, config *Config) TokenStore{
+		"dir": func(t *testing.T, config *Config) TokenStore {
+			return NewDirTokenStore(config)
+		},
+		"memory": func(t *testing.T, config *Config) TokenStore {
+			return NewMemoryTokenStore()
+		},
+		"encrypted": func(t *testing.T, config *Config) TokenStore {
+			store, err := NewEncryptedFileTokenStore("", newMockTokenKey(), config)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			config, _ := newMockTokenConfig(t)
+			store := newStore(t, config)
+			profileA := &Profile{Name: "a", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://a.example.com"}
+			profileB := &Profile{Name: "b", AccessID: "p-123", AccessKey: "key", GatewayURL: "https://b.example.com"}
+			token := &Token{AccessID: "p-123", Token: "t-a", Expiry: time.Now().Add(time.Hour).Truncate(time.Second)}
+			setProfileIdentity(token, profileA)
+
+			// Test case 1: An empty store has no token
+			if _, err := store.Get(profileA); !errors.Is(err, ErrNoCachedToken) {
+				t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+			}
+
+			// Test case 2: A stored token is returned for its profile only
+			if err := store.Put(profileA, token); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			got, err := store.Get(profileA)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if got.Expose() != token.Expose() {
+				t.Errorf("Expected token to be %+v, but got %+v", token.Expose(), got.Expose())
+			}
+			if _, err := store.Get(profileB); !errors.Is(err, ErrNoCachedToken) {
+				t.Errorf("Expected ErrNoCachedToken for another identity, but got %v", err)
+			}
+
+			// Test case 3: List returns the stored tokens
+			tokens, err := store.List()
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if len(tokens) != 1 || tokens[0].Token != "t-a" {
+				t.Errorf("Expected the stored token to be listed, but got %v", tokens)
+			}
+
+			// Test case 4: Delete removes the token, and deleting again is not an error
+			if err := store.Delete(profileA); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if _, err := store.Get(profileA); !errors.Is(err, ErrNoCachedToken) {
+				t.Errorf("Expected ErrNoCachedToken after Delete, but got %v", err)
+			}
+			if err := store.Delete(profileA); err != nil {
+				t.Errorf("Expected no error, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestGetTokenUsesTokenStore(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	runner := &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	config.Runner = runner
+	store := NewMemoryTokenStore()
+	config.TokenStore = store
+	profile := newMockProfile()
+
+	// Test case 1: A new token is put in the configured store and nothing is written to .tmp_creds
+	if _, err := GetToken(profile, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if tokens, _ := store.List(); len(tokens) != 1 {
+		t.Errorf("Expected the token to be stored, but got %v", tokens)
+	}
+	files, _ := afero.ReadDir(mockFs, "/path/to/akeyless/.tmp_creds")
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".lock") {
+			t.Errorf("Expected no token file in .tmp_creds, but got %s", file.Name())
+		}
+	}
+
+	// Test case 2: The next call is served from the store
+	token, err := GetToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-new" || len(runner.Calls()) != 1 {
+		t.Errorf("Expected the stored token without a second CLI call, but got %s after %d calls", token.Token, len(runner.Calls()))
+	}
+}
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	profile := newMockProfile()
+	token := &Token{AccessID: "p-123", Token: "t-plaintext-secret", AuthCreds: "creds-secret", Expiry: time.Now().Add(time.Hour)}
+
+	store, err := NewEncryptedFileTokenStore("", newMockTokenKey(), config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if err := store.Put(profile, token); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// Test case 1: The file does not contain the token in plaintext and is only readable by its owner
+	path := "/path/to/akeyless/.sheller_creds/" + profile.Fingerprint() + ".token"
+	data, err := afero.ReadFile(mockFs, path)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if bytes.Contains(data, []byte("t-plaintext-secret")) || bytes.Contains(data, []byte("creds-secret")) {
+		t.Errorf("Expected the token file to be encrypted, but got %s", data)
+	}
+	if info, _ := mockFs.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the token file mode to be 0600, but got %v", info.Mode().Perm())
+	}
+
+	// Test case 2: A store with a different key cannot read the token
+	otherStore, _ := NewEncryptedFileTokenStore("", bytes.Repeat([]byte{0x24}, EncryptedTokenKeySize), config)
+	if _, err := otherStore.Get(profile); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 3: A file copied over another profile's file fails to decrypt
+	other := &Profile{Name: "other", AccessID: "p-456", AccessKey: "key"}
+	afero.WriteFile(mockFs, "/path/to/akeyless/.sheller_creds/"+other.Fingerprint()+".token", data, 0600)
+	if _, err := store.Get(other); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 4: Keys of the wrong size are rejected
+	if _, err := NewEncryptedFileTokenStore("", []byte("short"), config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
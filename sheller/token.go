@@ -1,19 +1,23 @@
 package sheller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/pelletier/go-toml"
 )
 
 // Token holds the details of an authentication token.
+// Formatting, logging and JSON marshalling a Token never reveal the token or credentials, see token_format.go.
 type Token struct {
 	AccessID  string    `json:"access_id"`
 	Token     string    `json:"token"`
@@ -21,57 +25,128 @@ type Token struct {
 	AuthCreds string    `json:"auth_creds"`
 	UamCreds  string    `json:"uam_creds"`
 	KfmCreds  string    `json:"kfm_creds"`
+
+	// Identity of the profile the token was issued for, empty for tokens cached by the Akeyless CLI
+	AccessType         string `json:"access_type,omitempty"`
+	GatewayURL         string `json:"gateway_url,omitempty"`
+	ProfileFingerprint string `json:"profile_fingerprint,omitempty"` // See Profile.Fingerprint
 }
 
 type rawToken struct {
-	AccessID  string `json:"access_id"`
-	Token     string `json:"token"`
-	Expiry    int64 `json:"expiry"`
-	AuthCreds string `json:"auth_creds"`
-	UamCreds  string `json:"uam_creds"`
-	KfmCreds  string `json:"kfm_creds"`
+	AccessID           string `json:"access_id"`
+	Token              string `json:"token"`
+	Expiry             int64  `json:"expiry"`
+	AuthCreds          string `json:"auth_creds"`
+	UamCreds           string `json:"uam_creds"`
+	KfmCreds           string `json:"kfm_creds"`
+	AccessType         string `json:"access_type,omitempty"`
+	GatewayURL         string `json:"gateway_url,omitempty"`
+	ProfileFingerprint string `json:"profile_fingerprint,omitempty"`
 }
 
-// CheckForExistingToken checks for an existing valid token for the specified profile.
+// CheckForExistingToken checks the configured TokenStore for an existing valid token for the specified profile.
 func CheckForExistingToken(profile *Profile, config *Config) (*Token, error) {
+	return findCachedToken(profile, config, time.Now().Add(config.ExpiryBuffer))
+}
+
+// TokenCandidate describes a file found in the .tmp_creds directory while looking for a profile's token.
+type TokenCandidate struct {
+	Path    string // Path of the token file
+	Token   *Token // Parsed token, nil when the file could not be parsed
+	Err     error  // Why the file could not be used as a token file, if it could not
+	Matches bool   // Whether the token was issued for the profile, see tokenMatchesProfile
+	Valid   bool   // Whether the token is still valid after the expiry buffer
+}
+
+// Usable reports whether the candidate holds a valid token for the profile.
+func (c TokenCandidate) Usable() bool {
+	return c.Err == nil && c.Matches && c.Valid
+}
+
+// ScanTokenCache lists every token file in the .tmp_creds directory with what was found in it for the profile,
+// to diagnose why a cached token is or is not being used. Files that cannot be parsed are included with their error.
+func ScanTokenCache(profile *Profile, config *Config) ([]TokenCandidate, error) {
+	return scanTokenCache(profile, config, time.Now().Add(config.ExpiryBuffer))
+}
+
+// scanTokenCache parses every token file in the .tmp_creds directory and checks it against the profile and validAfter.
+func scanTokenCache(profile *Profile, config *Config, validAfter time.Time) ([]TokenCandidate, error) {
 	tokenFilesPath := filepath.Join(config.AkeylessPath, ".tmp_creds")
-	files, err := os.ReadDir(tokenFilesPath)
+	files, err := config.AppFs.ReadDir(tokenFilesPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", ErrNoCachedToken, err)
+		}
 		return nil, err
 	}
 
+	var candidates []TokenCandidate
 	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == "" { // Assuming token files have no extension
-			// get file name
-			fileName := file.Name()
-			fullPath := filepath.Join(tokenFilesPath, fileName)
-			token, err := ParseTokenFile(fullPath)
-			if err != nil {
-				return nil, err
-			}
-
-			// Check if the token's AccessID matches the profile's AccessID and the token is not expired
-			if token.AccessID == profile.AccessID && token.Expiry.After(time.Now().Add(config.ExpiryBuffer)) {
-				return token, nil
-			}
+		// Token files have no extension, which leaves out lock files and files being written
+		if file.IsDir() || filepath.Ext(file.Name()) != "" {
+			continue
+		}
+		candidate := TokenCandidate{Path: filepath.Join(tokenFilesPath, file.Name())}
+		token, err := ParseTokenFileWithConfig(candidate.Path, config)
+		switch {
+		case err != nil:
+			candidate.Err = err
+		case token.Token == "":
+			candidate.Err = errors.New("the file holds no token")
+		default:
+			candidate.Token = token
+			candidate.Matches = tokenMatchesProfile(token, profile)
+			candidate.Valid = token.Expiry.After(validAfter)
+		}
+		if candidate.Err != nil {
+			config.logger().Warn("skipping unreadable token cache file", "path", candidate.Path, "error", candidate.Err)
 		}
+		candidates = append(candidates, candidate)
 	}
 
-	return nil, errors.New("no valid token found")
+	return candidates, nil
+}
+
+// findCachedToken returns the token for the profile from the configured TokenStore if it is still valid after validAfter.
+func findCachedToken(profile *Profile, config *Config, validAfter time.Time) (*Token, error) {
+	store, err := config.tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	token, err := store.Get(profile)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Expiry.After(validAfter) {
+		return nil, fmt.Errorf("%w: the cached token expires at %s", ErrNoCachedToken, token.Expiry.Format(time.RFC3339))
+	}
+	return token, nil
 }
 
-// ParseTokenFile parses a token file and returns a Token struct.
+// ParseTokenFile parses a token file on the local filesystem and returns a Token struct.
 func ParseTokenFile(path string) (*Token, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return decodeTokenFile(data)
+}
 
-	var raw rawToken
-	err = json.Unmarshal(data, &raw)
+// ParseTokenFileWithConfig is like ParseTokenFile but reads the token file through config.AppFs.
+func ParseTokenFileWithConfig(path string, config *Config) (*Token, error) {
+	data, err := config.AppFs.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return decodeTokenFile(data)
+}
+
+// decodeTokenFile decodes the JSON layout of a .tmp_creds token file.
+func decodeTokenFile(data []byte) (*Token, error) {
+	var raw rawToken
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
 
 	token := &Token{
 		AccessID:  raw.AccessID,
@@ -80,74 +155,462 @@ func ParseTokenFile(path string) (*Token, error) {
 		AuthCreds: raw.AuthCreds,
 		UamCreds:  raw.UamCreds,
 		KfmCreds:  raw.KfmCreds,
+
+		AccessType:         raw.AccessType,
+		GatewayURL:         raw.GatewayURL,
+		ProfileFingerprint: raw.ProfileFingerprint,
 	}
 
 	return token, nil
 }
 
+// TokenFilePath returns the path of the .tmp_creds file that sheller writes tokens for the profile to.
+// The name is built from the profile name and access ID, restricted to characters that are safe in a file
+// name and without a dot so the file is picked up by CheckForExistingToken.
+func TokenFilePath(profile *Profile, config *Config) string {
+	name := sanitizeTokenFileName(profile.Name + "-" + profile.AccessID)
+	return filepath.Join(config.AkeylessPath, ".tmp_creds", name)
+}
+
+// sanitizeTokenFileName replaces every character other than letters, digits, hyphens and underscores with an underscore.
+func sanitizeTokenFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// WriteTokenFile writes a token to path in the same JSON layout the Akeyless CLI uses for its .tmp_creds files.
+// The file is written atomically with 0600 permissions and the parent directory is created if needed.
+func WriteTokenFile(token *Token, path string, config *Config) error {
+	data, err := encodeTokenFile(token)
+	if err != nil {
+		return err
+	}
+
+	if err := config.AppFs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(config, path, data, 0600)
+}
+
+// encodeTokenFile encodes a token in the JSON layout of a .tmp_creds token file.
+func encodeTokenFile(token *Token) ([]byte, error) {
+	return json.Marshal(rawToken{
+		AccessID:  token.AccessID,
+		Token:     token.Token,
+		Expiry:    token.Expiry.Unix(),
+		AuthCreds: token.AuthCreds,
+		UamCreds:  token.UamCreds,
+		KfmCreds:  token.KfmCreds,
+
+		AccessType:         token.AccessType,
+		GatewayURL:         token.GatewayURL,
+		ProfileFingerprint: token.ProfileFingerprint,
+	})
+}
+
+// SaveToken persists a token for the profile into the .tmp_creds cache so other processes can reuse it.
+func SaveToken(profile *Profile, token *Token, config *Config) error {
+	return WriteTokenFile(token, TokenFilePath(profile, config), config)
+}
+
 // convertUnderscoresToHyphens converts underscores to hyphens in a string.
 func convertUnderscoresToHyphens(s string) string {
 	return strings.ReplaceAll(s, "_", "-")
 }
 
+// buildAuthArgs builds the argv for "akeyless auth" from the settings of a profile.
+// Keys are sorted so the argv is deterministic and underscores are converted to hyphens. Values are passed
+// as separate arguments so spaces and quotes survive untouched. TOML types map to CLI flags as follows:
+//   - strings and numbers become "--key value"
+//   - true becomes the bare switch "--key" and false omits the flag
+//   - arrays repeat the flag once per element
+//
+// Any other type (tables, dates, nested arrays) is rejected with an error.
+func buildAuthArgs(cliPath string, profile *Profile) ([]string, error) {
+	args := []string{cliPath, "auth"}
+
+	settings := profile.Settings()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flagArgs, err := authFlagArgs(key, settings[key])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, flagArgs...)
+	}
+
+	return args, nil
+}
+
+// authFlagArgs converts a single profile key and its TOML value into CLI flag arguments.
+func authFlagArgs(key string, value interface{}) ([]string, error) {
+	flag := "--" + convertUnderscoresToHyphens(key)
+
+	if b, ok := value.(bool); ok {
+		if b {
+			return []string{flag}, nil
+		}
+		return nil, nil
+	}
+
+	if scalar, ok := formatScalarFlagValue(value); ok {
+		return []string{flag, scalar}, nil
+	}
+
+	// Arrays repeat the flag for every element, e.g. gcp_audience = ['a', 'b'] becomes --gcp-audience a --gcp-audience b
+	rv := reflect.ValueOf(value)
+	if value != nil && rv.Kind() == reflect.Slice {
+		var args []string
+		for i := 0; i < rv.Len(); i++ {
+			element := rv.Index(i).Interface()
+			scalar, ok := formatScalarFlagValue(element)
+			if !ok {
+				return nil, fmt.Errorf("unsupported array element type %T for profile key %q", element, key)
+			}
+			args = append(args, flag, scalar)
+		}
+		return args, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value type %T for profile key %q", value, key)
+}
+
+// formatScalarFlagValue formats a string or numeric TOML value as a CLI flag value.
+func formatScalarFlagValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
 // ShellOutForNewToken shells out to the Akeyless CLI to obtain a new token for the specified profile.
 func ShellOutForNewToken(profile *Profile, config *Config) (*Token, error) {
-	// Load the profile configuration file
-	profilePath := filepath.Join(config.AkeylessPath, "profiles", fmt.Sprintf("%s.toml", profile.Name))
-	profileConfig, err := toml.LoadFile(profilePath)
+	return ShellOutForNewTokenContext(context.Background(), profile, config)
+}
+
+// ShellOutForNewTokenContext is like ShellOutForNewToken but kills the Akeyless CLI when ctx is done.
+// The CLI is also given at most Config.AuthTimeout to authenticate, after which ErrAuthTimeout is returned.
+func ShellOutForNewTokenContext(ctx context.Context, profile *Profile, config *Config) (*Token, error) {
+	// Build the argv from the profile configuration, asking the CLI for the full JSON response
+	cmdParts, err := buildAuthArgs(config.CLIPath, profile)
 	if err != nil {
 		return nil, err
 	}
+	cmdParts = append(cmdParts, "--json")
 
-	// Construct the command string based on the profile and config
-	cmdStrBuilder := strings.Builder{}
-	fmt.Fprintf(&cmdStrBuilder, "%s auth", config.CLIPath)
+	// Check if the path points to an executable file
+	if _, err := config.AppFs.Stat(cmdParts[0]); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: the path does not point to an executable file", ErrCLINotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCLINotFound, err)
+	}
+
+	cmd := Command{Args: cmdParts}
+	redactedCommand := strings.Join(redactArgs(cmdParts), " ")
+	secrets := secretArgValues(cmdParts)
 
-	// Iterate through the profile configuration and append properties to the command string
-	profileConfigTree := profileConfig.Get(profile.Name).(*toml.Tree)
-	for _, key := range profileConfigTree.Keys() {
-		value := profileConfigTree.Get(key).(string)
-		cmdKey := convertUnderscoresToHyphens(key)
-		fmt.Fprintf(&cmdStrBuilder, " --%s %s", cmdKey, value)
+	authCtx := ctx
+	if config.AuthTimeout > 0 {
+		var cancel context.CancelFunc
+		authCtx, cancel = context.WithTimeout(ctx, config.AuthTimeout)
+		defer cancel()
 	}
 
-	cmdStr := cmdStrBuilder.String()
+	config.logger().Debug("invoking the Akeyless CLI", "profile", profile.Name, "command", redactedCommand)
+	started := time.Now()
+	result, err := config.commandRunner().Run(authCtx, cmd)
+	if result != nil {
+		config.logger().Debug("the Akeyless CLI finished", "profile", profile.Name, "exit_code", result.ExitCode, "duration", time.Since(started))
+	}
+	if err != nil {
+		// Runners may include the command line in their errors, so mask the secrets in it
+		err = redactError(err, secrets)
 
-	// append command to only return the token
-	cmdStr = cmdStr + " --json --jq-expression .token"
+		// Only report a timeout when it was our own deadline that expired, not the caller's
+		if ctx.Err() == nil && errors.Is(authCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrAuthTimeout, config.AuthTimeout)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// The executable may disappear between the check above and the run, or not be found by the runner at all
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrCLINotFound, err)
+		}
+		return nil, &AuthError{Profile: profile.Name, Command: redactedCommand, ExitCode: -1, Err: err}
+	}
+	if result.ExitCode != 0 {
+		return nil, &AuthError{
+			Profile:  profile.Name,
+			Command:  redactedCommand,
+			ExitCode: result.ExitCode,
+			Message:  redactSecrets(parseCLIErrorMessage(result.Stderr, result.Stdout), secrets),
+			Stderr:   redactSecrets(string(result.Stderr), secrets),
+		}
+	}
 
-	cmdParts := strings.Fields(cmdStr)
+	return parseAuthOutput(result.Stdout, profile, config)
+}
 
-	// Check if the path points to an executable file
-	if _, err := os.Stat(cmdParts[0]); os.IsNotExist(err) {
-		return nil, errors.New("the path does not point to an executable file")
+// parseCLIErrorMessage extracts the error message from the output of a failed CLI invocation.
+// With --json the CLI reports errors as a JSON object, which may be printed to stderr or stdout;
+// otherwise the trimmed stderr text is used as the message.
+func parseCLIErrorMessage(stderr, stdout []byte) string {
+	for _, output := range [][]byte{stderr, stdout} {
+		if msg := parseJSONErrorMessage(output); msg != "" {
+			return msg
+		}
 	}
+	return strings.TrimSpace(string(stderr))
+}
 
-	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
+// parseJSONErrorMessage returns the message from a JSON error object such as {"error": "..."} or
+// {"error": {"message": "..."}}, or an empty string when output is not a JSON error.
+func parseJSONErrorMessage(output []byte) string {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(output), &doc); err != nil {
+		return ""
+	}
+	return jsonErrorMessage(doc)
+}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// jsonErrorMessage looks for the error message in a decoded JSON error object.
+func jsonErrorMessage(doc map[string]interface{}) string {
+	for _, key := range []string{"error", "message", "msg", "err"} {
+		switch value := doc[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case map[string]interface{}:
+			if msg := jsonErrorMessage(value); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+// authOutput is the JSON document printed by "akeyless auth --json".
+// Depending on the CLI version the credentials are either at the top level or nested under "creds".
+type authOutput struct {
+	authCredsOutput
+	Creds      *authCredsOutput `json:"creds"`
+	Expiration cliExpiry        `json:"expiration"`
+}
+
+type authCredsOutput struct {
+	AccessID  string    `json:"access_id"`
+	Token     string    `json:"token"`
+	Expiry    cliExpiry `json:"expiry"`
+	AuthCreds string    `json:"auth_creds"`
+	UamCreds  string    `json:"uam_creds"`
+	KfmCreds  string    `json:"kfm_creds"`
+}
+
+// cliExpiry accepts the expiry formats the CLI has been seen to print: Unix seconds or milliseconds, as integers,
+// floats or strings, an RFC 3339 string or a Go time string. Any other value is kept in unrecognised rather than
+// failing the whole authentication, so the caller can fall back to the default TTL.
+type cliExpiry struct {
+	time.Time
+	unrecognised string
+}
+
+// cliExpiryLayouts are the time layouts tried for an expiry string that is not a Unix timestamp.
+var cliExpiryLayouts = []string{time.RFC3339, "2006-01-02 15:04:05.999999999 -0700 MST"}
+
+func (e *cliExpiry) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" || string(data) == `""` {
+		return nil
 	}
 
-	tokenCode := strings.TrimSpace(string(output))
+	var number float64
+	if err := json.Unmarshal(data, &number); err == nil {
+		e.Time = unixExpiry(int64(number))
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		e.unrecognised = string(data)
+		return nil
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		e.Time = unixExpiry(int64(number))
+		return nil
+	}
+	for _, layout := range cliExpiryLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			e.Time = t
+			return nil
+		}
+	}
+	e.unrecognised = text
+	return nil
+}
+
+// unixExpiry converts a Unix timestamp in seconds or milliseconds to a time, treating non-positive values as unset.
+func unixExpiry(value int64) time.Time {
+	switch {
+	case value > 1e12:
+		return time.UnixMilli(value)
+	case value > 0:
+		return time.Unix(value, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// parseAuthOutput builds a Token from the output of "akeyless auth --json".
+// When the CLI does not report an expiry the token is given the configured default TTL.
+func parseAuthOutput(output []byte, profile *Profile, config *Config) (*Token, error) {
+	var out authOutput
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse the Akeyless CLI auth output: %w", ErrAuthFailed, err)
+	}
+
+	creds := out.authCredsOutput
+	if out.Creds != nil {
+		creds = mergeAuthCreds(creds, *out.Creds)
+	}
+	if creds.Token == "" {
+		return nil, fmt.Errorf("%w: the Akeyless CLI auth output does not contain a token", ErrAuthFailed)
+	}
+
+	accessID := profile.AccessID
+	if accessID == "" {
+		accessID = creds.AccessID
+	}
+
+	expiry := creds.Expiry.Time
+	if expiry.IsZero() {
+		expiry = out.Expiration.Time
+	}
+	if expiry.IsZero() {
+		for _, e := range []cliExpiry{creds.Expiry, out.Expiration} {
+			if e.unrecognised != "" {
+				config.logger().Warn("ignoring an expiry the Akeyless CLI printed in an unrecognised format", "profile", profile.Name, "expiry", e.unrecognised, "default_ttl", config.defaultTokenTTL())
+			}
+		}
+		expiry = time.Now().Add(config.defaultTokenTTL())
+	}
 
 	token := &Token{
-		AccessID: profile.AccessID,
-		Token:    tokenCode,
-		Expiry:   time.Now().Add(1 * time.Hour), // Assuming token expiry is 1 hour
+		AccessID:  accessID,
+		Token:     creds.Token,
+		Expiry:    expiry.Truncate(time.Second), // the token cache stores whole seconds
+		AuthCreds: creds.AuthCreds,
+		UamCreds:  creds.UamCreds,
+		KfmCreds:  creds.KfmCreds,
 	}
+	setProfileIdentity(token, profile)
 
 	return token, nil
 }
 
+// mergeAuthCreds fills the empty fields of base with the values from nested.
+func mergeAuthCreds(base, nested authCredsOutput) authCredsOutput {
+	if base.AccessID == "" {
+		base.AccessID = nested.AccessID
+	}
+	if base.Token == "" {
+		base.Token = nested.Token
+	}
+	if base.Expiry.IsZero() {
+		base.Expiry = nested.Expiry
+	}
+	if base.AuthCreds == "" {
+		base.AuthCreds = nested.AuthCreds
+	}
+	if base.UamCreds == "" {
+		base.UamCreds = nested.UamCreds
+	}
+	if base.KfmCreds == "" {
+		base.KfmCreds = nested.KfmCreds
+	}
+	return base
+}
+
 // GetToken retrieves a token for the specified profile, either by reusing an existing valid token or by shelling out to the Akeyless CLI.
+// Refreshes are serialised across processes with a lock file per access ID, so when many processes miss the
+// cache at once only the first one authenticates and the others pick up the token it caches.
 func GetToken(profile *Profile, config *Config) (*Token, error) {
-	token, err := CheckForExistingToken(profile, config)
+	return GetTokenContext(context.Background(), profile, config)
+}
+
+// GetTokenContext is like GetToken but stops waiting for the refresh lock or the Akeyless CLI when ctx is done.
+func GetTokenContext(ctx context.Context, profile *Profile, config *Config) (*Token, error) {
+	return acquireToken(ctx, profile, config, time.Now().Add(config.ExpiryBuffer))
+}
+
+// acquireToken returns a cached token that is valid after validAfter, or authenticates through the CLI under the refresh lock.
+func acquireToken(ctx context.Context, profile *Profile, config *Config, validAfter time.Time) (*Token, error) {
+	log := config.logger().With("profile", profile.Name, "access_id", profile.AccessID)
+
+	token, err := findCachedToken(profile, config, validAfter)
 	if err == nil {
+		log.Debug("token cache hit", "expiry", token.Expiry)
+		return token, nil
+	}
+	log.Debug("token cache miss", "reason", err)
+
+	// Catch incomplete profiles here rather than through an opaque error from the CLI
+	if err := profile.Validate(config); err != nil {
+		return nil, err
+	}
+
+	lock, err := acquireRefreshLock(ctx, profile, config)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	// Another process may have refreshed the token while we were waiting for the lock
+	token, err = findCachedToken(profile, config, validAfter)
+	if err == nil {
+		log.Debug("token cache hit after waiting for the refresh lock", "expiry", token.Expiry)
 		return token, nil
 	}
 
 	// If no valid token found, shell out for a new token
-	return ShellOutForNewToken(profile, config)
+	token, err = ShellOutForNewTokenContext(ctx, profile, config)
+	if err != nil {
+		log.Warn("failed to obtain a new token", "error", err)
+		return nil, err
+	}
+	log.Info("obtained a new token", "expiry", token.Expiry)
+
+	// Failing to cache the token is not fatal, the next call will simply authenticate again
+	if store, err := config.tokenStore(); err != nil {
+		log.Warn("failed to open the token cache", "error", err)
+	} else if err := store.Put(profile, token); err != nil {
+		log.Warn("failed to save the token to the cache", "error", err)
+	}
+
+	// Pruning only removes expired tokens and stale temporary files, so other processes using the cache are unaffected
+	if config.AutoPrune {
+		if _, err := PruneTokenCache(config, PruneOptions{GracePeriod: DEFAULT_PRUNE_GRACE_PERIOD}); err != nil {
+			log.Warn("failed to prune the token cache", "error", err)
+		}
+	}
+
+	return token, nil
 }
@@ -0,0 +1,92 @@
+package sheller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Fingerprint identifies the token without revealing it: the first four characters followed by
+// the start of the token's SHA-256 hash, e.g. "t-ab…9f86d081". Very short tokens only show the hash.
+func (t Token) Fingerprint() string {
+	if t.Token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(t.Token))
+	hash := hex.EncodeToString(sum[:4])
+	if len(t.Token) <= 8 {
+		return hash
+	}
+	return t.Token[:4] + "…" + hash
+}
+
+// String returns a description of the token showing the access ID, expiry and fingerprint only.
+func (t Token) String() string {
+	creds := ""
+	if t.AuthCreds != "" || t.UamCreds != "" || t.KfmCreds != "" {
+		creds = ", Creds: " + redactedValue
+	}
+	return fmt.Sprintf("Token{AccessID: %s, Expiry: %s, Fingerprint: %s%s}", t.AccessID, t.Expiry.Format(time.RFC3339), t.Fingerprint(), creds)
+}
+
+// GoString returns the same safe description as String.
+func (t Token) GoString() string {
+	return t.String()
+}
+
+// Format implements fmt.Formatter so every verb, including %+v and %#v, prints the safe description.
+func (t Token) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'q':
+		fmt.Fprintf(f, "%q", t.String())
+	default:
+		fmt.Fprint(f, t.String())
+	}
+}
+
+// LogValue implements slog.LogValuer so logging a token records only non-secret attributes.
+func (t Token) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("access_id", t.AccessID),
+		slog.Time("expiry", t.Expiry),
+		slog.String("fingerprint", t.Fingerprint()),
+	)
+}
+
+// safeToken is the JSON form of a Token when the secret fields have not been explicitly exposed.
+type safeToken struct {
+	AccessID    string    `json:"access_id"`
+	Expiry      time.Time `json:"expiry"`
+	Fingerprint string    `json:"fingerprint"`
+
+	AccessType         string `json:"access_type,omitempty"`
+	GatewayURL         string `json:"gateway_url,omitempty"`
+	ProfileFingerprint string `json:"profile_fingerprint,omitempty"`
+}
+
+// MarshalJSON omits the token and credentials. Use Expose to marshal them.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(safeToken{
+		AccessID:    t.AccessID,
+		Expiry:      t.Expiry,
+		Fingerprint: t.Fingerprint(),
+
+		AccessType:         t.AccessType,
+		GatewayURL:         t.GatewayURL,
+		ProfileFingerprint: t.ProfileFingerprint,
+	})
+}
+
+// ExposedToken is a Token whose JSON encoding and formatting include the token and credentials.
+// It exists so revealing the secrets is always an explicit choice at the call site.
+type ExposedToken Token
+
+// Expose returns the token as an ExposedToken, for example to hand the full credentials to another process:
+//
+//	data, err := json.Marshal(token.Expose())
+func (t Token) Expose() ExposedToken {
+	return ExposedToken(t)
+}
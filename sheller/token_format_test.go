@@ -0,0 +1,74 @@
+package sheller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenFingerprint(t *testing.T) {
+	token := Token{Token: "t-0123456789abcdef"}
+	fingerprint := token.Fingerprint()
+	if !strings.HasPrefix(fingerprint, "t-01…") || len(fingerprint) != len("t-01…")+8 {
+		t.Errorf("Expected fingerprint to be the first 4 characters and a hash, but got %s", fingerprint)
+	}
+	if other := (Token{Token: "t-0123456789abcdeg"}).Fingerprint(); other == fingerprint {
+		t.Errorf("Expected different tokens to have different fingerprints")
+	}
+	if short := (Token{Token: "short"}).Fingerprint(); strings.Contains(short, "short") {
+		t.Errorf("Expected a short token not to be revealed, but got %s", short)
+	}
+	if empty := (Token{}).Fingerprint(); empty != "" {
+		t.Errorf("Expected an empty fingerprint, but got %s", empty)
+	}
+}
+
+func TestTokenSafeByDefault(t *testing.T) {
+	token := &Token{AccessID: "p-123", Token: "t-supersecrettoken", Expiry: time.Unix(1900000000, 0), AuthCreds: "auth-secret", UamCreds: "uam-secret", KfmCreds: "kfm-secret"}
+	secrets := []string{"t-supersecrettoken", "supersecret", "auth-secret", "uam-secret", "kfm-secret"}
+	assertSafe := func(what, output string) {
+		t.Helper()
+		for _, secret := range secrets {
+			if strings.Contains(output, secret) {
+				t.Errorf("Expected %s to hide %s, but got %s", what, secret, output)
+			}
+		}
+		if !strings.Contains(output, "p-123") || !strings.Contains(output, token.Fingerprint()) {
+			t.Errorf("Expected %s to show the access ID and fingerprint, but got %s", what, output)
+		}
+	}
+
+	// Every fmt verb
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+		assertSafe(format, fmt.Sprintf(format, token))
+		assertSafe(format, fmt.Sprintf(format, *token))
+	}
+
+	// Structured logging, including JSON handlers that would otherwise marshal the struct
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("token", "value", token)
+	assertSafe("slog", buf.String())
+
+	// JSON marshalling
+	data, err := json.Marshal(token)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	assertSafe("json.Marshal", string(data))
+
+	// Exposing the secrets is an explicit opt-in
+	data, err = json.Marshal(token.Expose())
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	for _, secret := range []string{"t-supersecrettoken", "auth-secret", "uam-secret", "kfm-secret"} {
+		if !strings.Contains(string(data), secret) {
+			t.Errorf("Expected the exposed token JSON to contain %s, but got %s", secret, data)
+		}
+	}
+}
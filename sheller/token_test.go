@@ -0,0 +1,524 @@
+package sheller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/afero"
+)
+
+// newMockTokenConfig returns a config backed by an in-memory filesystem with an empty .tmp_creds directory.
+func newMockTokenConfig(t *testing.T) (*Config, afero.Fs) {
+	t.Helper()
+	mockFs := afero.NewMemMapFs()
+	config := NewConfig("/path/to/cli", "default", "/path/to/akeyless", 10*time.Minute, false)
+	config.AppFs = &afero.Afero{Fs: mockFs}
+	mockFs.MkdirAll("/path/to/akeyless/.tmp_creds", 0700)
+	return config, mockFs
+}
+
+// writeMockTokenFile writes a token file in the Akeyless CLI format to the mock .tmp_creds directory.
+func writeMockTokenFile(t *testing.T, mockFs afero.Fs, name, accessID, token string, expiry time.Time) {
+	t.Helper()
+	data := fmt.Sprintf(`{"access_id":%q,"token":%q,"expiry":%d,"auth_creds":"a","uam_creds":"u","kfm_creds":"k"}`, accessID, token, expiry.Unix())
+	if err := afero.WriteFile(mockFs, "/path/to/akeyless/.tmp_creds/"+name, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write mock token file: %v", err)
+	}
+}
+
+// newMockProfile returns a valid access_key profile for the token tests.
+func newMockProfile() *Profile {
+	return &Profile{Name: "default", AccessID: "p-123", AccessType: "access_key", AccessKey: "key"}
+}
+
+func TestParseTokenFileWithConfig(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeMockTokenFile(t, mockFs, "token1", "p-123", "t-abc", expiry)
+
+	token, err := ParseTokenFileWithConfig("/path/to/akeyless/.tmp_creds/token1", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.AccessID != "p-123" {
+		t.Errorf("Expected AccessID to be 'p-123', but got %s", token.AccessID)
+	}
+	if token.Token != "t-abc" {
+		t.Errorf("Expected Token to be 't-abc', but got %s", token.Token)
+	}
+	if !token.Expiry.Equal(expiry) {
+		t.Errorf("Expected Expiry to be %s, but got %s", expiry, token.Expiry)
+	}
+	if token.AuthCreds != "a" || token.UamCreds != "u" || token.KfmCreds != "k" {
+		t.Errorf("Expected creds to be parsed, but got %q %q %q", token.AuthCreds, token.UamCreds, token.KfmCreds)
+	}
+
+	// A missing file must be reported as an error
+	if _, err := ParseTokenFileWithConfig("/path/to/akeyless/.tmp_creds/missing", config); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
+
+func TestParseTokenFile(t *testing.T) {
+	// ParseTokenFile keeps reading from the local filesystem for existing callers
+	path := filepath.Join(t.TempDir(), "token1")
+	if err := os.WriteFile(path, []byte(`{"access_id":"p-123","token":"t-abc","expiry":1900000000}`), 0600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	token, err := ParseTokenFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.AccessID != "p-123" || token.Token != "t-abc" || token.Expiry.Unix() != 1900000000 {
+		t.Errorf("Expected the token to be parsed, but got %+v", token.Expose())
+	}
+}
+
+func TestCheckForExistingToken(t *testing.T) {
+	profile := newMockProfile()
+
+	// Test case 1: A valid token for the profile exists
+	config1, mockFs1 := newMockTokenConfig(t)
+	writeMockTokenFile(t, mockFs1, "other", "p-999", "t-other", time.Now().Add(time.Hour))
+	writeMockTokenFile(t, mockFs1, "mine", "p-123", "t-mine", time.Now().Add(time.Hour))
+	token, err := CheckForExistingToken(profile, config1)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-mine" {
+		t.Errorf("Expected Token to be 't-mine', but got %s", token.Token)
+	}
+
+	// Test case 2: The only token for the profile expires within the expiry buffer
+	config2, mockFs2 := newMockTokenConfig(t)
+	writeMockTokenFile(t, mockFs2, "mine", "p-123", "t-mine", time.Now().Add(5*time.Minute))
+	if _, err := CheckForExistingToken(profile, config2); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 3: The .tmp_creds directory does not exist
+	config3, mockFs3 := newMockTokenConfig(t)
+	mockFs3.RemoveAll("/path/to/akeyless/.tmp_creds")
+	if _, err := CheckForExistingToken(profile, config3); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 4: Files with an extension and directories are ignored
+	config4, mockFs4 := newMockTokenConfig(t)
+	afero.WriteFile(mockFs4, "/path/to/akeyless/.tmp_creds/notes.txt", []byte("not json"), 0600)
+	mockFs4.MkdirAll("/path/to/akeyless/.tmp_creds/subdir", 0700)
+	writeMockTokenFile(t, mockFs4, "mine", "p-123", "t-mine", time.Now().Add(time.Hour))
+	token, err = CheckForExistingToken(profile, config4)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-mine" {
+		t.Errorf("Expected Token to be 't-mine', but got %s", token.Token)
+	}
+}
+
+func TestCheckForExistingTokenSkipsCorruptFiles(t *testing.T) {
+	profile := newMockProfile()
+	config, mockFs := newMockTokenConfig(t)
+	var logs bytes.Buffer
+	config.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	// Corrupt, half-written and unrelated files sort before the valid tokens
+	afero.WriteFile(mockFs, "/path/to/akeyless/.tmp_creds/a-half-written", []byte(`{"access_id":"p-123","tok`), 0600)
+	afero.WriteFile(mockFs, "/path/to/akeyless/.tmp_creds/b-unrelated", []byte(`{"name":"something else"}`), 0600)
+	writeMockTokenFile(t, mockFs, "c-short", "p-123", "t-short", time.Now().Add(30*time.Minute))
+	writeMockTokenFile(t, mockFs, "d-long", "p-123", "t-long", time.Now().Add(2*time.Hour))
+	writeMockTokenFile(t, mockFs, "e-medium", "p-123", "t-medium", time.Now().Add(time.Hour))
+
+	// Test case 1: Unparseable files are skipped and the token that expires last is chosen
+	token, err := CheckForExistingToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-long" {
+		t.Errorf("Expected Token to be 't-long', but got %s", token.Token)
+	}
+	if !strings.Contains(logs.String(), "a-half-written") || !strings.Contains(logs.String(), "b-unrelated") {
+		t.Errorf("Expected the skipped files to be logged, but got %s", logs.String())
+	}
+
+	// Test case 2: Every file is listed as a candidate with what was found in it
+	candidates, err := ScanTokenCache(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(candidates) != 5 {
+		t.Fatalf("Expected 5 candidates, but got %d", len(candidates))
+	}
+	if candidates[0].Err == nil || candidates[1].Err == nil || candidates[0].Usable() {
+		t.Errorf("Expected the corrupt files to carry an error, but got %v and %v", candidates[0], candidates[1])
+	}
+	for _, candidate := range candidates[2:] {
+		if !candidate.Usable() || candidate.Path == "" {
+			t.Errorf("Expected %s to be usable, but got %+v", candidate.Path, candidate)
+		}
+	}
+
+	// Test case 3: Only corrupt files means there is no cached token
+	mockFs.RemoveAll("/path/to/akeyless/.tmp_creds")
+	mockFs.MkdirAll("/path/to/akeyless/.tmp_creds", 0700)
+	afero.WriteFile(mockFs, "/path/to/akeyless/.tmp_creds/corrupt", []byte("{"), 0600)
+	if _, err := CheckForExistingToken(profile, config); !errors.Is(err, ErrNoCachedToken) {
+		t.Errorf("Expected ErrNoCachedToken, but got %v", err)
+	}
+}
+
+// writeMockProfile writes a profile file and a fake CLI executable to the mock filesystem.
+func writeMockProfile(t *testing.T, mockFs afero.Fs, name, contents string) {
+	t.Helper()
+	mockFs.MkdirAll("/path/to/akeyless/profiles", 0755)
+	if err := afero.WriteFile(mockFs, "/path/to/akeyless/profiles/"+name+".toml", []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write mock profile: %v", err)
+	}
+	if err := afero.WriteFile(mockFs, "/path/to/cli", []byte{}, 0755); err != nil {
+		t.Fatalf("Failed to write mock CLI: %v", err)
+	}
+}
+
+func TestShellOutForNewToken(t *testing.T) {
+	profile := newMockProfile()
+
+	// Test case 1: The CLI returns a token
+	config1, mockFs1 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs1, "default", "[default]\naccess_id = 'p-123'\n")
+	expiry := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	runner1 := &FakeCommandRunner{Results: []FakeResult{{Stdout: fmt.Sprintf(`{"token":"t-new","creds":{"expiry":%d,"auth_creds":"a"}}`, expiry.Unix())}}}
+	config1.Runner = runner1
+	token, err := ShellOutForNewToken(profile, config1)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-new" {
+		t.Errorf("Expected Token to be 't-new', but got %s", token.Token)
+	}
+	if token.AccessID != "p-123" {
+		t.Errorf("Expected AccessID to be 'p-123', but got %s", token.AccessID)
+	}
+	if !token.Expiry.Equal(expiry) {
+		t.Errorf("Expected Expiry to be %s, but got %s", expiry, token.Expiry)
+	}
+	calls := runner1.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 CLI call, but got %d", len(calls))
+	}
+	if calls[0].Args[0] != "/path/to/cli" || calls[0].Args[1] != "auth" {
+		t.Errorf("Expected the CLI to be invoked with auth, but got %v", calls[0].Args)
+	}
+
+	// Test case 2: The CLI exits with a non-zero status
+	config2, mockFs2 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs2, "default", "[default]\naccess_id = 'p-123'\n")
+	config2.Runner = &FakeCommandRunner{Results: []FakeResult{{Stderr: "access denied", ExitCode: 1}}}
+	if _, err := ShellOutForNewToken(profile, config2); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+
+	// Test case 3: The CLI could not be started
+	config3, mockFs3 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs3, "default", "[default]\naccess_id = 'p-123'\n")
+	config3.Runner = &FakeCommandRunner{Results: []FakeResult{{Err: fmt.Errorf("exec format error")}}}
+	if _, err := ShellOutForNewToken(profile, config3); err == nil {
+		t.Errorf("Expected error, but got none")
+	}
+}
+
+func TestBuildAuthArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cliPath  string
+		profile  string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "simple access key profile",
+			cliPath:  "/usr/local/bin/akeyless",
+			profile:  "access_id = 'p-123'\naccess_key = 'secret'\naccess_type = 'access_key'\n",
+			expected: []string{"/usr/local/bin/akeyless", "auth", "--access-id", "p-123", "--access-key", "secret", "--access-type", "access_key"},
+		},
+		{
+			name:     "cli path with spaces",
+			cliPath:  "/Program Files/akeyless",
+			profile:  "access_id = 'p-123'\n",
+			expected: []string{"/Program Files/akeyless", "auth", "--access-id", "p-123"},
+		},
+		{
+			name:     "values with spaces are kept as one argument",
+			cliPath:  "akeyless",
+			profile:  "cert_file_name = '/home/me/My Certs/cert.pem'\ngcp_audience = 'akeyless audience'\n",
+			expected: []string{"akeyless", "auth", "--cert-file-name", "/home/me/My Certs/cert.pem", "--gcp-audience", "akeyless audience"},
+		},
+		{
+			name:     "quotes and empty values are preserved exactly",
+			cliPath:  "akeyless",
+			profile:  "jwt = \"a'b\\\"c\"\nuid_token = ''\n",
+			expected: []string{"akeyless", "auth", "--jwt", "a'b\"c", "--uid-token", ""},
+		},
+		{
+			name:     "booleans become bare switches and false is omitted",
+			cliPath:  "akeyless",
+			profile:  "debug = true\nuse_remote_browser = false\n",
+			expected: []string{"akeyless", "auth", "--debug"},
+		},
+		{
+			name:     "numbers are formatted",
+			cliPath:  "akeyless",
+			profile:  "ttl = 60\nratio = 1.5\n",
+			expected: []string{"akeyless", "auth", "--ratio", "1.5", "--ttl", "60"},
+		},
+		{
+			name:     "arrays become repeated flags",
+			cliPath:  "akeyless",
+			profile:  "gcp_audience = ['first audience', 'second']\n",
+			expected: []string{"akeyless", "auth", "--gcp-audience", "first audience", "--gcp-audience", "second"},
+		},
+		{
+			name:    "nested arrays are rejected",
+			cliPath: "akeyless",
+			profile: "gcp_audience = [['a'], ['b']]\n",
+			wantErr: true,
+		},
+		{
+			name:    "dates are rejected",
+			cliPath: "akeyless",
+			profile: "created = 1979-05-27T07:32:00Z\n",
+			wantErr: true,
+		},
+		{
+			name:    "nested tables are rejected",
+			cliPath: "akeyless",
+			profile: "[nested]\nkey = 'value'\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := toml.Load(tt.profile)
+			if err != nil {
+				t.Fatalf("Failed to load profile: %v", err)
+			}
+			args, err := buildAuthArgs(tt.cliPath, decodeProfile("test", tree))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("Expected args to be %q, but got %q", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestParseAuthOutput(t *testing.T) {
+	profile := newMockProfile()
+	config := NewConfig("", "default", "", 0, false)
+	config.DefaultTTL = 2 * time.Hour
+	expiry := time.Unix(1900000000, 0)
+
+	tests := []struct {
+		name          string
+		output        string
+		expectedToken Token
+		expectDefault bool
+		wantErr       bool
+	}{
+		{
+			name:          "credentials nested under creds",
+			output:        `{"token":"t-1","creds":{"token":"t-1","expiry":1900000000,"auth_creds":"a","uam_creds":"u","kfm_creds":"k"}}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-1", Expiry: expiry, AuthCreds: "a", UamCreds: "u", KfmCreds: "k"},
+		},
+		{
+			name:          "credentials at the top level with millisecond expiry",
+			output:        `{"token":"t-2","expiry":1900000000000,"auth_creds":"a"}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-2", Expiry: expiry, AuthCreds: "a"},
+		},
+		{
+			name:          "RFC 3339 expiration",
+			output:        `{"token":"t-3","expiration":"` + expiry.UTC().Format(time.RFC3339) + `"}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-3", Expiry: expiry},
+		},
+		{
+			name:          "missing expiry falls back to the default TTL",
+			output:        `{"token":"t-4"}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-4"},
+			expectDefault: true,
+		},
+		{
+			name:          "float expiry",
+			output:        `{"token":"t-6","expiry":1900000000.0}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-6", Expiry: expiry},
+		},
+		{
+			name:          "Go time string expiration",
+			output:        `{"token":"t-7","expiration":"` + expiry.UTC().String() + `"}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-7", Expiry: expiry},
+		},
+		{
+			name:          "unrecognised expiry falls back to the default TTL",
+			output:        `{"token":"t-8","expiry":"next tuesday","expiration":{"seconds":1}}`,
+			expectedToken: Token{AccessID: "p-123", Token: "t-8"},
+			expectDefault: true,
+		},
+		{
+			name:    "missing token",
+			output:  `{"creds":{}}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			output:  "t-5",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			token, err := parseAuthOutput([]byte(tt.output), profile, config)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if tt.expectDefault {
+				if token.Expiry.Before(before.Add(config.DefaultTTL).Add(-time.Second)) || token.Expiry.After(time.Now().Add(config.DefaultTTL)) {
+					t.Errorf("Expected Expiry to be about %s from now, but got %s", config.DefaultTTL, token.Expiry)
+				}
+				tt.expectedToken.Expiry = token.Expiry
+			}
+			if !token.Expiry.Equal(tt.expectedToken.Expiry) {
+				t.Errorf("Expected Expiry to be %s, but got %s", tt.expectedToken.Expiry, token.Expiry)
+			}
+			if token.AccessType != "access_key" || token.ProfileFingerprint != profile.Fingerprint() {
+				t.Errorf("Expected the token to carry the profile identity, but got %q and %q", token.AccessType, token.ProfileFingerprint)
+			}
+			token.Expiry = tt.expectedToken.Expiry
+			token.AccessType, token.GatewayURL, token.ProfileFingerprint = "", "", ""
+			if *token != tt.expectedToken {
+				t.Errorf("Expected token to be %+v, but got %+v", tt.expectedToken, *token)
+			}
+		})
+	}
+}
+
+func TestWriteTokenFile(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	mockFs.RemoveAll("/path/to/akeyless/.tmp_creds")
+	profile := &Profile{Name: "my.profile", AccessID: "p-123"}
+	token := &Token{AccessID: "p-123", Token: "t-abc", Expiry: time.Unix(1900000000, 0), AuthCreds: "a", UamCreds: "u", KfmCreds: "k"}
+
+	path := TokenFilePath(profile, config)
+	if path != "/path/to/akeyless/.tmp_creds/my_profile-p-123" {
+		t.Errorf("Expected token file path to be '/path/to/akeyless/.tmp_creds/my_profile-p-123', but got %s", path)
+	}
+
+	if err := SaveToken(profile, token, config); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	info, err := mockFs.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected token file to exist, but got %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token file permissions to be 0600, but got %o", info.Mode().Perm())
+	}
+
+	parsed, err := ParseTokenFileWithConfig(path, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if *parsed != *token {
+		t.Errorf("Expected parsed token to be %+v, but got %+v", *token, *parsed)
+	}
+
+	// Only the token file must remain, the temporary file is renamed into place
+	entries, _ := afero.ReadDir(mockFs, "/path/to/akeyless/.tmp_creds")
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 file in .tmp_creds, but got %d", len(entries))
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\n")
+	runner := &FakeCommandRunner{Results: []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}}
+	config.Runner = runner
+	profile := newMockProfile()
+
+	// The first call misses the cache and shells out
+	token, err := GetToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-new" {
+		t.Errorf("Expected Token to be 't-new', but got %s", token.Token)
+	}
+
+	// The second call is served from the token written to the cache
+	token, err = GetToken(profile, config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if token.Token != "t-new" {
+		t.Errorf("Expected Token to be 't-new', but got %s", token.Token)
+	}
+	if calls := len(runner.Calls()); calls != 1 {
+		t.Errorf("Expected 1 CLI call, but got %d", calls)
+	}
+}
+
+func TestShellOutForNewTokenContext(t *testing.T) {
+	profile := newMockProfile()
+	hangingRunner := &FakeCommandRunner{
+		Handler: func(ctx context.Context, cmd Command) (*CommandResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	// Test case 1: The CLI hangs past the configured auth timeout
+	config1, mockFs1 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs1, "default", "[default]\naccess_id = 'p-123'\n")
+	config1.Runner = hangingRunner
+	config1.AuthTimeout = 20 * time.Millisecond
+	if _, err := ShellOutForNewTokenContext(context.Background(), profile, config1); !errors.Is(err, ErrAuthTimeout) {
+		t.Errorf("Expected ErrAuthTimeout, but got %v", err)
+	}
+
+	// Test case 2: The caller's context is cancelled before the auth timeout
+	config2, mockFs2 := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs2, "default", "[default]\naccess_id = 'p-123'\n")
+	config2.Runner = hangingRunner
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := GetTokenContext(ctx, profile, config2)
+	if errors.Is(err, ErrAuthTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, but got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package sheller
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// accessTypeRule lists the profile keys an Akeyless access type needs.
+type accessTypeRule struct {
+	required []string   // Keys that must be set
+	oneOf    [][]string // Groups of keys where at least one key of each group must be set
+	optional []string   // Keys the access type understands but does not need
+}
+
+// accessTypeRules holds the requirements of every access type supported by "akeyless auth".
+var accessTypeRules = map[string]accessTypeRule{
+	"access_key":         {required: []string{"access_id", "access_key"}},
+	"password":           {required: []string{"admin_email", "admin_password"}},
+	"saml":               {required: []string{"access_id"}, optional: []string{"use_remote_browser"}},
+	"oidc":               {required: []string{"access_id"}, optional: []string{"use_remote_browser"}},
+	"ldap":               {required: []string{"access_id", "username", "password"}, optional: []string{"ldap_proxy_url"}},
+	"azure_ad":           {required: []string{"access_id"}, optional: []string{"azure_ad_object_id"}},
+	"aws_iam":            {required: []string{"access_id"}},
+	"gcp":                {required: []string{"access_id"}, optional: []string{"gcp_audience"}},
+	"k8s":                {required: []string{"access_id", "k8s_auth_config_name"}, optional: []string{"gateway_url", "k8s_service_account_token"}},
+	"cert":               {required: []string{"access_id"}, oneOf: [][]string{{"cert_file_name", "cert_data"}, {"key_file_name", "key_data"}}},
+	"universal_identity": {required: []string{"uid_token"}, optional: []string{"access_id"}},
+	"jwt":                {required: []string{"access_id", "jwt"}},
+	"oci":                {required: []string{"access_id", "oci_group_ocid"}, optional: []string{"oci_auth_type"}},
+}
+
+// defaultAccessType is the access type "akeyless auth" assumes when none is given.
+const defaultAccessType = "access_key"
+
+// profileFileKeys are profile keys that reference files, which must exist and be regular files.
+var profileFileKeys = []string{"cert_file_name", "key_file_name"}
+
+// profileURLKeys are profile keys that must hold absolute URLs.
+var profileURLKeys = []string{"gateway_url", "ldap_proxy_url"}
+
+// FieldProblem describes a single problem with one profile field.
+type FieldProblem struct {
+	Field   string // Profile key, e.g. "access_key"
+	Message string
+}
+
+func (p FieldProblem) String() string {
+	return p.Field + ": " + p.Message
+}
+
+// ProfileValidationError lists every problem found by Profile.Validate. It matches ErrProfileInvalid with errors.Is.
+type ProfileValidationError struct {
+	Profile    string
+	AccessType string
+	Problems   []FieldProblem
+}
+
+func (e *ProfileValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.String()
+	}
+	return fmt.Sprintf("profile %q is not valid for access type %q: %s", e.Profile, e.AccessType, strings.Join(problems, "; "))
+}
+
+// Is reports whether target is ErrProfileInvalid.
+func (e *ProfileValidationError) Is(target error) bool {
+	return target == ErrProfileInvalid
+}
+
+// Validate checks that the profile has the fields its access type needs before the Akeyless CLI is invoked,
+// that referenced files exist on config.AppFs and that URLs are well formed. Access types sheller does not know,
+// such as ones added to the CLI later, are logged and left for the CLI to check.
+// It returns nil or a *ProfileValidationError listing every problem found.
+func (p *Profile) Validate(config *Config) error {
+	accessType := p.AccessType
+	if accessType == "" {
+		accessType = defaultAccessType
+	}
+
+	var problems []FieldProblem
+	settings := p.Settings()
+	isSet := func(key string) bool {
+		value, ok := settings[key]
+		return ok && value != ""
+	}
+
+	rule, ok := accessTypeRules[accessType]
+	if !ok {
+		config.logger().Warn("skipping the field checks of an unknown access type", "profile", p.Name, "access_type", accessType, "known", strings.Join(supportedAccessTypes(), ", "))
+	}
+
+	for _, key := range rule.required {
+		if !isSet(key) {
+			problems = append(problems, FieldProblem{Field: key, Message: "is required"})
+		}
+	}
+	for _, group := range rule.oneOf {
+		found := false
+		for _, key := range group {
+			found = found || isSet(key)
+		}
+		if !found {
+			problems = append(problems, FieldProblem{Field: group[0], Message: "one of " + strings.Join(group, " or ") + " is required"})
+		}
+	}
+
+	for _, key := range profileFileKeys {
+		path, ok := settings[key].(string)
+		if !ok || path == "" {
+			continue
+		}
+		info, err := config.AppFs.Stat(path)
+		switch {
+		case err != nil:
+			problems = append(problems, FieldProblem{Field: key, Message: fmt.Sprintf("the file %s cannot be accessed: %v", path, err)})
+		case info.IsDir():
+			problems = append(problems, FieldProblem{Field: key, Message: fmt.Sprintf("the path %s is a directory", path)})
+		}
+	}
+
+	for _, key := range profileURLKeys {
+		value, ok := settings[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			problems = append(problems, FieldProblem{Field: key, Message: fmt.Sprintf("%q is not an absolute URL", value)})
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ProfileValidationError{Profile: p.Name, AccessType: accessType, Problems: problems}
+}
+
+// AccessTypeFields returns the profile keys an access type requires and the ones it optionally accepts.
+// Groups where any one key satisfies the requirement, such as cert_file_name or cert_data, are listed as optional.
+func AccessTypeFields(accessType string) (required, optional []string, ok bool) {
+	rule, ok := accessTypeRules[accessType]
+	if !ok {
+		return nil, nil, false
+	}
+	required = append(required, rule.required...)
+	optional = append(optional, rule.optional...)
+	for _, group := range rule.oneOf {
+		optional = append(optional, group...)
+	}
+	return required, optional, true
+}
+
+// supportedAccessTypes returns the names of the access types Validate knows, sorted.
+func supportedAccessTypes() []string {
+	types := make([]string, 0, len(accessTypeRules))
+	for accessType := range accessTypeRules {
+		types = append(types, accessType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,150 @@
+package sheller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestProfileValidate(t *testing.T) {
+	config, mockFs := newMockProfileConfig(t)
+	afero.WriteFile(mockFs, "/certs/client.crt", []byte("cert"), 0600)
+	afero.WriteFile(mockFs, "/certs/client.key", []byte("key"), 0600)
+	mockFs.MkdirAll("/certs/directory", 0700)
+
+	tests := []struct {
+		name     string
+		profile  Profile
+		problems []string // expected problem fields, in order
+	}{
+		{
+			name:    "valid access key",
+			profile: Profile{AccessID: "p-1", AccessType: "access_key", AccessKey: "key"},
+		},
+		{
+			name:     "access key is the default access type",
+			profile:  Profile{AccessID: "p-1"},
+			problems: []string{"access_key"},
+		},
+		{
+			name:     "access key without anything",
+			profile:  Profile{AccessType: "access_key"},
+			problems: []string{"access_id", "access_key"},
+		},
+		{
+			name:    "valid cert with files",
+			profile: Profile{AccessID: "p-1", AccessType: "cert", CertFileName: "/certs/client.crt", KeyFileName: "/certs/client.key"},
+		},
+		{
+			name:    "valid cert with inline data",
+			profile: Profile{AccessID: "p-1", AccessType: "cert", CertData: "Y2VydA==", KeyData: "a2V5"},
+		},
+		{
+			name:     "cert with missing file and directory",
+			profile:  Profile{AccessID: "p-1", AccessType: "cert", CertFileName: "/certs/missing.crt", KeyFileName: "/certs/directory"},
+			problems: []string{"cert_file_name", "key_file_name"},
+		},
+		{
+			name:     "cert without cert or key",
+			profile:  Profile{AccessID: "p-1", AccessType: "cert"},
+			problems: []string{"cert_file_name", "key_file_name"},
+		},
+		{
+			name:    "valid k8s",
+			profile: Profile{AccessID: "p-1", AccessType: "k8s", K8sAuthConfigName: "conf", GatewayURL: "https://gw:8000"},
+		},
+		{
+			name:     "k8s with bad gateway url",
+			profile:  Profile{AccessID: "p-1", AccessType: "k8s", GatewayURL: "gw:8000/api"},
+			problems: []string{"k8s_auth_config_name", "gateway_url"},
+		},
+		{
+			name:    "universal identity does not need an access id",
+			profile: Profile{AccessType: "universal_identity", UIDToken: "u-1"},
+		},
+		{
+			name:    "saml only needs an access id",
+			profile: Profile{AccessID: "p-1", AccessType: "saml"},
+		},
+		{
+			name:     "ldap",
+			profile:  Profile{AccessID: "p-1", AccessType: "ldap", Username: "me"},
+			problems: []string{"password"},
+		},
+		{
+			name:    "unknown access type is left to the CLI",
+			profile: Profile{AccessType: "kerberos"},
+		},
+		{
+			name:     "unknown access type still checks urls",
+			profile:  Profile{AccessID: "p-1", AccessType: "kerberos", GatewayURL: "gw:8000/api"},
+			problems: []string{"gateway_url"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.profile.Name = "test"
+			err := tt.profile.Validate(config)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Expected no error, but got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrProfileInvalid) {
+				t.Fatalf("Expected ErrProfileInvalid, but got %v", err)
+			}
+			var validationErr *ProfileValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a ProfileValidationError, but got %#v", err)
+			}
+			var fields []string
+			for _, problem := range validationErr.Problems {
+				fields = append(fields, problem.Field)
+			}
+			if len(fields) != len(tt.problems) {
+				t.Fatalf("Expected problems with %v, but got %v", tt.problems, validationErr.Problems)
+			}
+			for i := range fields {
+				if fields[i] != tt.problems[i] {
+					t.Errorf("Expected problems with %v, but got %v", tt.problems, validationErr.Problems)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTokenValidatesProfile(t *testing.T) {
+	config, mockFs := newMockTokenConfig(t)
+	writeMockProfile(t, mockFs, "default", "[default]\naccess_id = 'p-123'\naccess_type = 'access_key'\n")
+	runner := &FakeCommandRunner{}
+	config.Runner = runner
+
+	profile, err := GetProfile("default", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := GetToken(profile, config); !errors.Is(err, ErrProfileInvalid) {
+		t.Errorf("Expected ErrProfileInvalid, but got %v", err)
+	}
+	if calls := len(runner.Calls()); calls != 0 {
+		t.Errorf("Expected the CLI not to be invoked, but got %d calls", calls)
+	}
+
+	// A profile with an access type sheller does not know is passed on to the CLI
+	writeMockProfile(t, mockFs, "kerberos", "[kerberos]\naccess_id = 'p-123'\naccess_type = 'kerberos'\n")
+	runner.Results = []FakeResult{{Stdout: `{"token":"t-new","expiry":1900000000}`}}
+	profile, err = GetProfile("kerberos", config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if _, err := GetToken(profile, config); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+	if calls := len(runner.Calls()); calls != 1 {
+		t.Errorf("Expected the CLI to be invoked once, but got %d calls", calls)
+	}
+}